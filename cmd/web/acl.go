@@ -0,0 +1,184 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Errors
+var (
+	ErrInvalidCannedACL   = errors.New("x-amz-acl must be one of private, public-read, public-read-write, authenticated-read")
+	ErrNoSuchBucketPolicy = errors.New("the bucket policy does not exist")
+)
+
+// Canned ACLs given in the x-amz-acl header
+const (
+	aclPrivate           = "private"
+	aclPublicRead        = "public-read"
+	aclPublicReadWrite   = "public-read-write"
+	aclAuthenticatedRead = "authenticated-read"
+)
+
+// Actions granted by the READ and the WRITE permissions of the canned ACLs
+var (
+	aclReadActions = map[string]bool{
+		"s3:ListBucket":         true,
+		"s3:ListBucketVersions": true,
+		"s3:GetObject":          true,
+		"s3:GetObjectVersion":   true,
+	}
+	aclWriteActions = map[string]bool{
+		"s3:PutObject":                  true,
+		"s3:DeleteObject":               true,
+		"s3:DeleteObjectVersion":        true,
+		"s3:AbortMultipartUpload":       true,
+		"s3:ListMultipartUploadParts":   true,
+		"s3:ListBucketMultipartUploads": true,
+	}
+)
+
+// Returns the canned ACL of the request, empty when the header is not given.
+// ACLs are only enforced along with authentication, so while it is off the private ACL is ignored
+// and the ACLs granting access are rejected
+func cannedACLFromRequest(r *http.Request) (string, error) {
+	acl := r.Header.Get("X-Amz-Acl")
+	switch acl {
+	case "":
+		return acl, nil
+	case aclPrivate:
+		if credentials == nil {
+			return "", nil
+		}
+		return acl, nil
+	case aclPublicRead, aclPublicReadWrite, aclAuthenticatedRead:
+		if credentials == nil {
+			return "", ErrAuthenticationDisabled
+		}
+		return acl, nil
+	default:
+		return "", ErrInvalidCannedACL
+	}
+}
+
+// Reports whether the canned ACL grants the action to a caller who is not the root,
+// authenticated tells whether the caller signed the request
+func cannedACLAllows(acl, action string, authenticated bool) bool {
+	switch acl {
+	case aclPublicReadWrite:
+		return aclReadActions[action] || aclWriteActions[action]
+	case aclPublicRead:
+		return aclReadActions[action]
+	case aclAuthenticatedRead:
+		return authenticated && aclReadActions[action]
+	default:
+		return false
+	}
+}
+
+// Returns the canned ACL governing the action along with the bucket policy.
+// Reads of an object version with its own ACL are governed by it, everything else by the ACL of the bucket.
+// The requested version of the read must exist
+func accessControlOf(bucketName, objectKey, versionID, action string) (string, *policyDocument, error) {
+	store.mu.RLock()
+	bucket, exists := store.buckets[bucketName]
+	if !exists {
+		store.mu.RUnlock()
+		return "", nil, nil
+	}
+	acl, policy := bucket.acl, bucket.policy
+	store.mu.RUnlock()
+
+	if objectKey != "" && (action == "s3:GetObject" || action == "s3:GetObjectVersion") {
+		bucket, err := store.rlockBucket(bucketName)
+		if err != nil {
+			return acl, policy, nil
+		}
+		object, _, err := findObjectVersion(bucket, objectKey, versionID)
+		bucket.mu.RUnlock()
+		if err == ErrNoSuchVersion || err == ErrInvalidVersionID {
+			return "", nil, err
+		} else if err == nil && object.acl != "" {
+			acl = object.acl
+		}
+	}
+	return acl, policy, nil
+}
+
+// GET /<bucket>?policy handler
+func getBucketPolicy(w http.ResponseWriter, bucketName string) error {
+	store.mu.RLock()
+	bucket, exists := store.buckets[bucketName]
+	policyJSON := ""
+	if exists {
+		policyJSON = bucket.policyJSON
+	}
+	store.mu.RUnlock()
+	if !exists {
+		return ErrBucketNotExists
+	} else if policyJSON == "" {
+		return ErrNoSuchBucketPolicy
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(policyJSON))
+	return nil
+}
+
+// PUT /<bucket>?policy handler, the body is the JSON policy document.
+// Policies are only enforced along with authentication, so they cannot be set while it is off
+func putBucketPolicy(r *http.Request, bucketName string) error {
+	if credentials == nil {
+		return ErrAuthenticationDisabled
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, bytesIn1mb))
+	if err != nil {
+		return fmt.Errorf("error while reading the policy of <%s> bucket: %w", bucketName, err)
+	}
+	policy, err := parseBucketPolicy(body, bucketName)
+	if err != nil {
+		return err
+	}
+	err = setBucketPolicy(bucketName, string(body), policy)
+	if err != nil {
+		return err
+	}
+	log.Printf("policy of <%s> bucket updated", bucketName)
+	return nil
+}
+
+// DELETE /<bucket>?policy handler
+func deleteBucketPolicy(bucketName string) error {
+	err := setBucketPolicy(bucketName, "", nil)
+	if err != nil {
+		return err
+	}
+	log.Printf("policy of <%s> bucket deleted", bucketName)
+	return nil
+}
+
+// Replaces the policy of the bucket and persists the buckets metadata
+func setBucketPolicy(bucketName, policyJSON string, policy *policyDocument) error {
+	return setBucketConfig(bucketName, func(bucket *bucketData) func() {
+		previousJSON, previous := bucket.policyJSON, bucket.policy
+		bucket.policyJSON, bucket.policy = policyJSON, policy
+		return func() {
+			bucket.policyJSON, bucket.policy = previousJSON, previous
+		}
+	})
+}
+
+// Parses the stored bucket policy, an empty policy is no policy
+func loadBucketPolicy(bucket *bucketData) error {
+	if strings.TrimSpace(bucket.policyJSON) == "" {
+		return nil
+	}
+	policy, err := parseBucketPolicy([]byte(bucket.policyJSON), bucket.Name)
+	if err != nil {
+		return fmt.Errorf("error while parsing policy of <%s> bucket: %w", bucket.Name, err)
+	}
+	bucket.policy = policy
+	return nil
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix of the temporary files, encoded object names and bucket names never start with a dot
+const tempFilePrefix = ".tmp-"
+
+// Creates a temporary file in the directory, it is later renamed over the target in the same directory
+func createTempFile(dir string) (*os.File, error) {
+	return os.CreateTemp(dir, tempFilePrefix+"*")
+}
+
+// Flushes the temporary file to disk and closes it
+func closeTempFile(tempFile *os.File) error {
+	err := tempFile.Sync()
+	if err != nil {
+		tempFile.Close()
+		return err
+	}
+	return tempFile.Close()
+}
+
+// Moves the closed temporary file over the target and persists the directory entry
+func renameTempFile(tempPath, path string) error {
+	err := os.Rename(tempPath, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Replaces the file with the content written by the callback,
+// readers and crashes see either the old or the new content but never a partial one
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tempFile, err := createTempFile(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("error while creating temporary file for <%s>: %w", path, err)
+	}
+	tempPath := tempFile.Name()
+
+	err = write(tempFile)
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	err = closeTempFile(tempFile)
+	if err == nil {
+		err = renameTempFile(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error while replacing <%s>: %w", path, err)
+	}
+	return nil
+}
+
+// Flushes the directory so that renames and new entries survive a crash
+func syncDir(dir string) error {
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+	return dirFile.Sync()
+}
+
+// Reports whether the file name belongs to a temporary file
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix)
+}
+
+// Removes the temporary files left in the directory by writes interrupted by a crash
+func removeTempFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error while reading <%s> directory: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isTempFile(entry.Name()) {
+			continue
+		}
+		err := os.Remove(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("error while removing temporary file <%s>: %w", entry.Name(), err)
+		}
+		log.Printf("removed temporary file <%s> in <%s>", entry.Name(), dir)
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrAccessDenied                 = errors.New("access denied")
+	ErrSignatureDoesNotMatch        = errors.New("the request signature we calculated does not match the signature you provided")
+	ErrInvalidAccessKeyID           = errors.New("the access key ID you provided does not exist in our records")
+	ErrRequestTimeTooSkewed         = errors.New("the difference between the request time and the server's time is too large")
+	ErrAuthorizationHeaderMalformed = errors.New("the authorization header is malformed")
+	ErrAuthorizationQueryMalformed  = errors.New("the authorization query parameters are malformed")
+	ErrRequestExpired               = errors.New("request has expired")
+	ErrContentSHA256Mismatch        = errors.New("the provided x-amz-content-sha256 header does not match what was computed")
+)
+
+// Signature Version 4 constants
+const (
+	signatureAlgorithm       = "AWS4-HMAC-SHA256"
+	signatureService         = "s3"
+	signatureScopeTerminator = "aws4_request"
+	amzDateFormat            = "20060102T150405Z"
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingTrailerPayload  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	// SHA-256 of the empty string
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// Maximum difference between the request time and the server time
+	maxRequestTimeSkew = 15 * time.Minute
+	// Maximum lifetime of a presigned request, 7 days
+	maxPresignedExpires = 7 * 24 * 60 * 60
+)
+
+// Secret keys by access key ID, loaded from the --credentials file.
+// Requests are not authenticated when no credentials are configured
+var credentials map[string]string
+
+// Loads access key ID and secret access key pairs, one pair per CSV record
+func loadCredentials(path string) (map[string]string, error) {
+	credentialsFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening credentials file: %w", err)
+	}
+	defer credentialsFile.Close()
+
+	records, err := csv.NewReader(credentialsFile).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading credentials file: %w", err)
+	}
+	loaded := map[string]string{}
+	for _, record := range records {
+		if len(record) != 2 || record[0] == "" || record[1] == "" {
+			return nil, ErrInvalidNumberOfFields
+		}
+		loaded[record[0]] = record[1]
+	}
+	return loaded, nil
+}
+
+// signature describes a Signature Version 4 signature of the request
+type signature struct {
+	accessKeyID   string
+	date          time.Time
+	region        string
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	// Seconds of validity of a presigned request, 0 for the Authorization header
+	expires int
+}
+
+// Scope of the signing key, <date>/<region>/s3/aws4_request
+func (s signature) scope() string {
+	return strings.Join([]string{s.date.Format("20060102"), s.region, signatureService, signatureScopeTerminator}, "/")
+}
+
+// Authenticated caller of the request
+type requestIdentity struct {
+	accessKeyID string
+	// User owning the access key, empty for the root keys of the credentials file
+	userName string
+	// Verifies the chunk signatures of a streaming upload, nil for other payloads
+	chunkSigner *chunkSigner
+}
+
+type identityContextKey struct{}
+
+// Returns the identity attached to the request by authenticateRequest, nil for anonymous requests
+func identityFromRequest(r *http.Request) *requestIdentity {
+	identity, _ := r.Context().Value(identityContextKey{}).(*requestIdentity)
+	return identity
+}
+
+// Verifies the signature of the request in the Authorization header or in the query string
+// and returns the request carrying the identity of the caller.
+// All requests are let through when no credentials are configured
+func authenticateRequest(r *http.Request) (*http.Request, error) {
+	if credentials == nil {
+		return r, nil
+	}
+
+	var sig signature
+	var err error
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), signatureAlgorithm+" "):
+		sig, err = parseAuthorizationHeader(r)
+	case query.Get("X-Amz-Algorithm") == signatureAlgorithm:
+		sig, err = parseAuthorizationQuery(r)
+	case r.Header.Get("Authorization") != "" || query.Has("X-Amz-Algorithm"):
+		// Signature Version 2 and other schemes are not supported
+		return nil, ErrAuthorizationHeaderMalformed
+	default:
+		// Anonymous request, authorize decides what the bucket policies and ACLs let it do
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	errMismatch := ErrSignatureDoesNotMatch
+	if sig.expires > 0 {
+		errMismatch = ErrPresignedSignatureMismatch
+	}
+	// The signature must cover the host so that it cannot be replayed against another host
+	if !containsString(sig.signedHeaders, "host") {
+		return nil, errMismatch
+	}
+
+	secretKey, userName, exists := lookupAccessKey(sig.accessKeyID)
+	if !exists {
+		return nil, ErrInvalidAccessKeyID
+	}
+
+	now := time.Now().UTC()
+	if sig.expires > 0 {
+		if now.Before(sig.date.Add(-maxRequestTimeSkew)) {
+			return nil, ErrRequestTimeTooSkewed
+		} else if now.After(sig.date.Add(time.Duration(sig.expires) * time.Second)) {
+			return nil, ErrRequestExpired
+		}
+	} else if now.Sub(sig.date) > maxRequestTimeSkew || sig.date.Sub(now) > maxRequestTimeSkew {
+		return nil, ErrRequestTimeTooSkewed
+	}
+
+	signingKey := deriveSigningKey(secretKey, sig.date, sig.region)
+	canonicalRequest := buildCanonicalRequest(r, sig)
+	stringToSign := strings.Join([]string{signatureAlgorithm, sig.date.Format(amzDateFormat), sig.scope(), hashHex([]byte(canonicalRequest))}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(sig.signature)) != 1 {
+		return nil, errMismatch
+	}
+
+	identity := &requestIdentity{accessKeyID: sig.accessKeyID, userName: userName}
+	switch sig.payloadHash {
+	case unsignedPayload:
+	case streamingPayload, streamingTrailerPayload:
+		identity.chunkSigner = &chunkSigner{
+			signingKey:        signingKey,
+			date:              sig.date.Format(amzDateFormat),
+			scope:             sig.scope(),
+			previousSignature: sig.signature,
+		}
+	default:
+		// Unsigned streaming payloads are not checked, other payloads are verified once they are read
+		if !strings.HasPrefix(sig.payloadHash, "STREAMING-") && r.Body != nil {
+			r.Body = &payloadHashReader{ReadCloser: r.Body, hash: sha256.New(), expected: sig.payloadHash}
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity)), nil
+}
+
+// Parses "AWS4-HMAC-SHA256 Credential=<key>/<scope>, SignedHeaders=<headers>, Signature=<signature>"
+func parseAuthorizationHeader(r *http.Request) (signature, error) {
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), signatureAlgorithm+" "), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return signature{}, ErrAuthorizationHeaderMalformed
+		}
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate == "" {
+		// Date header is used when X-Amz-Date is absent
+		date, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return signature{}, ErrAuthorizationHeaderMalformed
+		}
+		amzDate = date.UTC().Format(amzDateFormat)
+	}
+	// S3 requires the payload hash, generic SigV4 clients omit it for empty bodies
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" && r.ContentLength == 0 {
+		payloadHash = emptyPayloadHash
+	} else if payloadHash == "" {
+		return signature{}, ErrAuthorizationHeaderMalformed
+	}
+	sig, err := newSignature(fields["Credential"], fields["SignedHeaders"], fields["Signature"], amzDate, payloadHash)
+	if err != nil {
+		return signature{}, ErrAuthorizationHeaderMalformed
+	}
+	return sig, nil
+}
+
+// Parses the X-Amz-* query parameters of a presigned request
+func parseAuthorizationQuery(r *http.Request) (signature, error) {
+	query := r.URL.Query()
+	// Payload of a presigned request is unsigned unless the client sent its hash
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+	}
+	sig, err := newSignature(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature"), query.Get("X-Amz-Date"), payloadHash)
+	if err != nil {
+		return signature{}, ErrAuthorizationQueryMalformed
+	}
+	sig.expires, err = strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || sig.expires <= 0 || sig.expires > maxPresignedExpires {
+		return signature{}, ErrAuthorizationQueryMalformed
+	}
+	return sig, nil
+}
+
+func newSignature(credential, signedHeaders, signatureValue, amzDate, payloadHash string) (signature, error) {
+	// <access key>/<date>/<region>/s3/aws4_request
+	credentialParts := strings.Split(credential, "/")
+	if len(credentialParts) != 5 || credentialParts[3] != signatureService || credentialParts[4] != signatureScopeTerminator {
+		return signature{}, ErrAuthorizationHeaderMalformed
+	}
+	date, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil || date.Format("20060102") != credentialParts[1] {
+		return signature{}, ErrAuthorizationHeaderMalformed
+	}
+	if signedHeaders == "" || signatureValue == "" {
+		return signature{}, ErrAuthorizationHeaderMalformed
+	}
+	return signature{
+		accessKeyID:   credentialParts[0],
+		date:          date,
+		region:        credentialParts[2],
+		signedHeaders: strings.Split(signedHeaders, ";"),
+		signature:     signatureValue,
+		payloadHash:   payloadHash,
+	}, nil
+}
+
+// Builds the canonical request:
+//
+//	<method>\n<canonical URI>\n<canonical query string>\n<canonical headers>\n<signed headers>\n<payload hash>
+func buildCanonicalRequest(r *http.Request, sig signature) string {
+	// Query parameters sorted by name then value, the signature itself is not signed
+	var queryPairs []string
+	for name, values := range r.URL.Query() {
+		if sig.expires > 0 && name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			queryPairs = append(queryPairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(queryPairs)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range sig.signedHeaders {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		default:
+			values = r.Header.Values(name)
+		}
+		for i, value := range values {
+			// Sequential spaces are collapsed
+			values[i] = strings.Join(strings.Fields(value), " ")
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		strings.Join(queryPairs, "&"),
+		canonicalHeaders.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+// Percent-encodes every byte except the unreserved characters, slashes are kept unless encodeSlash is set
+func uriEncode(value string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			encoded.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			encoded.WriteByte('%')
+			encoded.WriteByte(hexDigits[c>>4])
+			encoded.WriteByte(hexDigits[c&15])
+		}
+	}
+	return encoded.String()
+}
+
+func deriveSigningKey(secretKey string, date time.Time, region string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), []byte(date.Format("20060102")))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(signatureService))
+	return hmacSHA256(key, []byte(signatureScopeTerminator))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// payloadHashReader fails the read which reaches the end of the body if the body does not match
+// the signed X-Amz-Content-Sha256, staged uploads are discarded on the error
+type payloadHashReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (p *payloadHashReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.hash.Write(b[:n])
+	if err == io.EOF && hex.EncodeToString(p.hash.Sum(nil)) != p.expected {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// chunkSigner verifies the signatures of aws-chunked chunks, each chunk is signed over the previous signature:
+//
+//	AWS4-HMAC-SHA256-PAYLOAD\n<date>\n<scope>\n<previous signature>\n<empty string hash>\n<chunk data hash>
+//
+// Trailing headers are signed with AWS4-HMAC-SHA256-TRAILER over the hash of the "name:value\n" lines
+type chunkSigner struct {
+	signingKey        []byte
+	date              string
+	scope             string
+	previousSignature string
+}
+
+// Checks the signature of the chunk with the given data hash
+func (c *chunkSigner) verifyChunk(chunkSignature string, dataHash []byte) error {
+	stringToSign := strings.Join([]string{signatureAlgorithm + "-PAYLOAD", c.date, c.scope, c.previousSignature, emptyPayloadHash, hex.EncodeToString(dataHash)}, "\n")
+	return c.verify(stringToSign, chunkSignature)
+}
+
+// Checks the signature of the trailing headers
+func (c *chunkSigner) verifyTrailer(trailerSignature string, trailer []byte) error {
+	stringToSign := strings.Join([]string{signatureAlgorithm + "-TRAILER", c.date, c.scope, c.previousSignature, hashHex(trailer)}, "\n")
+	return c.verify(stringToSign, trailerSignature)
+}
+
+func (c *chunkSigner) verify(stringToSign, providedSignature string) error {
+	expected := hex.EncodeToString(hmacSHA256(c.signingKey, []byte(stringToSign)))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(providedSignature)) != 1 {
+		return ErrSignatureDoesNotMatch
+	}
+	c.previousSignature = expected
+	return nil
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"io"
+)
+
+// Backend persists the buckets, the objects and their metadata.
+// The handlers keep the metadata in the store and call the backend under the locks described there,
+// so implementations only have to be safe for calls on different buckets and uploads in parallel
+type Backend interface {
+	// Loads the buckets along with the metadata records of their objects
+	LoadBuckets() ([]*bucketData, error)
+	// Persists the records of all buckets
+	SaveBuckets(buckets []*bucketData) error
+	// Creates the storage of an empty bucket
+	CreateBucket(bucketName string) error
+	// Removes the storage of the bucket including its staged uploads,
+	// returns ErrBucketIsNotEmpty if any object content is left
+	DeleteBucket(bucketName string) error
+	// Persists the changes of the bucket's objects metadata, objects is the index with the changes applied
+	SaveObjects(bucketName string, objects *objectIndex, changes []objectChange) error
+	// Persists the changes of the bucket's noncurrent versions, versions are the lists with the changes applied.
+	// A change which is not a removal adds the newest noncurrent version of its key or replaces the listed one
+	SaveVersions(bucketName string, versions map[string][]bucketObject, changes []objectChange) error
+
+	// Opens the content of the object
+	OpenObject(bucketName, objectKey string) (ObjectReader, error)
+	// Starts writing a new content of the object, which replaces the current one once committed
+	CreateObject(bucketName, objectKey string) (ObjectWriter, error)
+	// Removes the content of the object, removing a missing object is not an error
+	RemoveObject(bucketName, objectKey string) error
+
+	// Keeps the current content of the object as the content of its noncurrent version,
+	// the current content stays in place until it is replaced or removed
+	ArchiveObject(bucketName, objectKey, versionID string) error
+	// Makes the content of the noncurrent version the current content of the object again
+	RestoreObject(bucketName, objectKey, versionID string) error
+	// Opens the content of the noncurrent version
+	OpenVersion(bucketName, objectKey, versionID string) (ObjectReader, error)
+	// Removes the content of the noncurrent version, removing a missing version is not an error
+	RemoveVersion(bucketName, objectKey, versionID string) error
+
+	// Loads the staged multipart uploads of the bucket along with their parts
+	LoadUploads(bucketName string) ([]*multipartUpload, error)
+	// Persists the description of a new upload
+	CreateUpload(upload *multipartUpload) error
+	// Starts writing the content of the part, which replaces the previous one once committed
+	CreatePart(upload *multipartUpload, partNumber int) (ObjectWriter, error)
+	// Persists the record of the committed part
+	SavePart(upload *multipartUpload, part uploadPart) error
+	// Opens the content of the part
+	OpenPart(upload *multipartUpload, partNumber int) (ObjectReader, error)
+	// Removes the upload with its parts
+	RemoveUpload(upload *multipartUpload) error
+}
+
+// ObjectReader streams a stored content, ranges are read with ReadAt
+type ObjectReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// ObjectWriter stages a new content. Close flushes it to durable storage,
+// Commit closes it if needed and replaces the current content, it is called under the bucket lock.
+// Abort discards the content unless it was committed and is safe to defer
+type ObjectWriter interface {
+	io.WriteCloser
+	Commit() error
+	Abort()
+}
+
+// objectChange is a change of the objects metadata, a removal only carries the key in the object
+type objectChange struct {
+	removed bool
+	object  bucketObject
+}
+
+func removedObjectChange(objectKey string) objectChange {
+	return objectChange{removed: true, object: bucketObject{objectKey: objectKey}}
+}
+
+// Global backend of the storage, set by Init
+var backend Backend
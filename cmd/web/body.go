@@ -0,0 +1,239 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Errors
+var (
+	ErrIncompleteBody          = errors.New("request body is shorter than the declared content length")
+	ErrInvalidChunkedEncoding  = errors.New("aws-chunked request body is malformed")
+	ErrInvalidDecodedLength    = errors.New("x-amz-decoded-content-length header is incorrect")
+	ErrUnexpectedContentLength = errors.New("request body is longer than the declared content length")
+)
+
+// Maximum length of an aws-chunked chunk header or trailer line
+const maxChunkLineLength = 4096
+
+// Reports whether the request body is encoded with aws-chunked encoding
+func isAWSChunked(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if strings.TrimSpace(encoding) == "aws-chunked" {
+			return true
+		}
+	}
+	return strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-")
+}
+
+// Returns the decoded request body and its expected length, -1 when the length is not known upfront.
+// Transfer-Encoding: chunked is already decoded by net/http, aws-chunked payloads are decoded here
+func requestBody(r *http.Request) (io.Reader, int64, error) {
+	if !isAWSChunked(r) {
+		return r.Body, r.ContentLength, nil
+	}
+
+	expectedLength := int64(-1)
+	if decodedLength := r.Header.Get("X-Amz-Decoded-Content-Length"); decodedLength != "" {
+		length, err := strconv.ParseInt(decodedLength, 10, 64)
+		if err != nil || length < 0 {
+			return nil, 0, ErrInvalidDecodedLength
+		}
+		expectedLength = length
+	}
+	var signer *chunkSigner
+	if identity := identityFromRequest(r); identity != nil {
+		signer = identity.chunkSigner
+	}
+	return newAWSChunkedReader(r.Body, signer), expectedLength, nil
+}
+
+// Copies the body into dst enforcing the limit on the bytes actually received.
+// Returns the number of written bytes and the hex encoded MD5 of them
+func receiveBody(dst io.Writer, body io.Reader, expectedLength, limit int64) (int64, string, error) {
+	if expectedLength > limit {
+		return 0, "", ErrTooBigObject
+	}
+
+	hasher := md5.New()
+	written, err := io.Copy(io.MultiWriter(dst, hasher), io.LimitReader(body, limit+1))
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return written, "", ErrIncompleteBody
+		} else if errors.Is(err, ErrInvalidChunkedEncoding) {
+			return written, "", ErrInvalidChunkedEncoding
+		} else if errors.Is(err, ErrSignatureDoesNotMatch) {
+			return written, "", ErrSignatureDoesNotMatch
+		}
+		return written, "", err
+	}
+
+	if written > limit {
+		return written, "", ErrTooBigObject
+	} else if expectedLength >= 0 && written < expectedLength {
+		return written, "", ErrIncompleteBody
+	} else if expectedLength >= 0 && written > expectedLength {
+		return written, "", ErrUnexpectedContentLength
+	}
+	return written, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// awsChunkedReader decodes the aws-chunked content encoding used by the AWS SDKs for streaming uploads:
+//
+//	<hex size>[;chunk-signature=<signature>]\r\n<data>\r\n ... 0[;chunk-signature=<signature>]\r\n[trailers]\r\n
+//
+// With a signer every chunk and the signed trailers are verified, a chunk is verified once it is read whole
+type awsChunkedReader struct {
+	reader *bufio.Reader
+	// Bytes left in the current chunk
+	remaining int64
+	// Data of the previous chunk must be followed by CRLF
+	needCRLF bool
+	err      error
+
+	signer         *chunkSigner
+	chunkSignature string
+	chunkHash      hash.Hash
+}
+
+func newAWSChunkedReader(body io.Reader, signer *chunkSigner) *awsChunkedReader {
+	return &awsChunkedReader{reader: bufio.NewReaderSize(body, maxChunkLineLength), signer: signer, chunkHash: sha256.New()}
+}
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if c.remaining == 0 {
+		if c.needCRLF {
+			line, err := c.readLine()
+			if err != nil {
+				return 0, c.fail(err)
+			} else if len(line) != 0 {
+				return 0, c.fail(ErrInvalidChunkedEncoding)
+			}
+			c.needCRLF = false
+		}
+
+		size, err := c.readChunkHeader()
+		if err != nil {
+			return 0, c.fail(err)
+		}
+		if size == 0 {
+			err = c.verifyChunk()
+			if err != nil {
+				return 0, c.fail(err)
+			}
+			err = c.readTrailer()
+			if err != nil {
+				return 0, c.fail(err)
+			}
+			c.err = io.EOF
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.remaining -= int64(n)
+	c.chunkHash.Write(p[:n])
+	if c.remaining == 0 {
+		c.needCRLF = true
+		if verifyErr := c.verifyChunk(); verifyErr != nil {
+			return n, c.fail(verifyErr)
+		}
+	}
+	if err == io.EOF {
+		// Body ended in the middle of a chunk
+		return n, c.fail(io.ErrUnexpectedEOF)
+	} else if err != nil {
+		return n, c.fail(err)
+	}
+	return n, nil
+}
+
+// Parses "<hex size>[;chunk-signature=<signature>]" line
+func (c *awsChunkedReader) readChunkHeader() (int64, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return 0, err
+	}
+	sizeValue, extension, _ := bytes.Cut(line, []byte(";"))
+	size, err := strconv.ParseInt(string(bytes.TrimSpace(sizeValue)), 16, 64)
+	if err != nil || size < 0 {
+		return 0, ErrInvalidChunkedEncoding
+	}
+	signature, _ := bytes.CutPrefix(extension, []byte("chunk-signature="))
+	c.chunkSignature = string(signature)
+	return size, nil
+}
+
+// Verifies the signature of the chunk which was just read
+func (c *awsChunkedReader) verifyChunk() error {
+	dataHash := c.chunkHash.Sum(nil)
+	c.chunkHash.Reset()
+	if c.signer == nil {
+		return nil
+	}
+	return c.signer.verifyChunk(c.chunkSignature, dataHash)
+}
+
+// Reads the trailing headers up to the empty line and verifies their signature
+func (c *awsChunkedReader) readTrailer() error {
+	var trailer bytes.Buffer
+	trailerSignature := ""
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		} else if len(line) == 0 {
+			break
+		}
+		name, value, _ := bytes.Cut(line, []byte(":"))
+		if string(bytes.ToLower(bytes.TrimSpace(name))) == "x-amz-trailer-signature" {
+			trailerSignature = string(bytes.TrimSpace(value))
+			continue
+		}
+		trailer.Write(bytes.TrimSpace(line))
+		trailer.WriteByte('\n')
+	}
+	if c.signer == nil || trailer.Len() == 0 {
+		return nil
+	}
+	return c.signer.verifyTrailer(trailerSignature, trailer.Bytes())
+}
+
+// Reads the line without the trailing CRLF
+func (c *awsChunkedReader) readLine() ([]byte, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err == bufio.ErrBufferFull {
+			return nil, ErrInvalidChunkedEncoding
+		}
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidChunkedEncoding
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *awsChunkedReader) fail(err error) error {
+	c.err = err
+	return err
+}
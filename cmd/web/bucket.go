@@ -1,14 +1,12 @@
 package web
 
 import (
-	"encoding/csv"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -26,11 +24,40 @@ var (
 
 // bucketData csv record structure
 type bucketData struct {
-	Name             string `xml:"Name"`
-	CreatedTime      string `xml:"CreationDate"`
-	LastModifiedTime string `xml:"LastModifiedDate"`
-	Status           string `xml:"Status"`
-	objects          *[]bucketObject
+	Name        string
+	CreatedTime string
+
+	// statsMu guards the last modification time and the status, they follow the objects and are
+	// written to buckets.csv only along with the bucket-level changes. It is taken after all other locks
+	statsMu          sync.Mutex
+	LastModifiedTime string
+	Status           string
+
+	// Canned ACL, empty for buckets created before ACLs which are private
+	acl string
+	// Raw JSON of the bucket policy and its parsed form, empty and nil when there is none
+	policyJSON string
+	policy     *policyDocument
+	// Versioning status, empty until versioning is enabled for the first time
+	versioning string
+	// Canonical XML of the lifecycle configuration and its parsed form, empty and nil when there is none
+	lifecycleXML string
+	lifecycle    *lifecycleConfiguration
+	// Canonical XML of the CORS configuration and its parsed form, empty and nil when there is none
+	corsXML string
+	cors    *corsConfiguration
+	// Canonical XML of the website configuration and its parsed form, empty and nil when there is none
+	websiteXML string
+	website    *websiteConfiguration
+
+	// mu guards objects, versions and deleted, the configurations are guarded by the store lock
+	mu      sync.RWMutex
+	objects *objectIndex
+	// Noncurrent versions and delete markers by key, newest first. A key whose latest version
+	// is a delete marker is not in objects, the latest version which is an object always is
+	versions map[string][]bucketObject
+	// Set once the bucket is removed from the store
+	deleted bool
 }
 
 var ProhibitedStoragePaths = []string{
@@ -39,17 +66,72 @@ var ProhibitedStoragePaths = []string{
 }
 
 type bucketsWrapper struct {
-	XMLName xml.Name      `xml:"Buckets"`
-	Buckets []*bucketData `xml:"Bucket"`
+	XMLName xml.Name       `xml:"Buckets"`
+	Buckets []listedBucket `xml:"Bucket"`
+}
+
+type listedBucket struct {
+	Name             string `xml:"Name"`
+	CreatedTime      string `xml:"CreationDate"`
+	LastModifiedTime string `xml:"LastModifiedDate"`
+	Status           string `xml:"Status"`
+}
+
+// Returns the status and the last modification time of the bucket
+func (bucket *bucketData) stats() (string, string) {
+	bucket.statsMu.Lock()
+	defer bucket.statsMu.Unlock()
+	return bucket.Status, bucket.LastModifiedTime
+}
+
+// Updates the status and the last modification time after the objects changed,
+// the caller must hold the bucket lock for writing
+func (bucket *bucketData) touch() {
+	status := "inactive"
+	if bucket.objects.len() > 0 {
+		status = "active"
+	}
+	bucket.statsMu.Lock()
+	defer bucket.statsMu.Unlock()
+	bucket.Status = status
+	bucket.LastModifiedTime = time.Now().Format(time.RFC822)
+}
+
+// Brings the status and the last modification time read from buckets.csv up to date with the loaded objects
+func (bucket *bucketData) loadStats() {
+	bucket.Status = "inactive"
+	if bucket.objects.len() > 0 {
+		bucket.Status = "active"
+	}
+	var latest time.Time
+	bucket.objects.ascend("", func(object bucketObject) bool {
+		if lastModified, err := parseObjectTime(object.lastModified); err == nil && lastModified.After(latest) {
+			latest = lastModified
+		}
+		return true
+	})
+	persisted, err := time.Parse(time.RFC822, bucket.LastModifiedTime)
+	if !latest.IsZero() && (err != nil || latest.After(persisted)) {
+		bucket.LastModifiedTime = latest.Format(time.RFC822)
+	}
 }
 
 // GET handler
 func getBuckets(w http.ResponseWriter) error {
 	wrapper := bucketsWrapper{}
 
-	for _, bucket := range bucketMap {
-		wrapper.Buckets = append(wrapper.Buckets, bucket)
-	}
+	store.mu.RLock()
+	for _, bucketName := range store.sortedNames() {
+		bucket := store.buckets[bucketName]
+		status, lastModified := bucket.stats()
+		wrapper.Buckets = append(wrapper.Buckets, listedBucket{
+			Name:             bucket.Name,
+			CreatedTime:      bucket.CreatedTime,
+			LastModifiedTime: lastModified,
+			Status:           status,
+		})
+	}
+	store.mu.RUnlock()
 	marshalledObject, err := xml.MarshalIndent(wrapper, "", "    ")
 	if err != nil {
 		return fmt.Errorf("error while marshaling the buckets: %w", err)
@@ -61,138 +143,109 @@ func getBuckets(w http.ResponseWriter) error {
 
 var prohibitedBucketNames = []string{
 	"buckets.csv",
+	"journal.log",
 }
 
-// PUT handler
-func createBucket(bucketName string) error {
+// PUT handler, acl is the canned ACL of the bucket
+func createBucket(bucketName, acl string) error {
 	// Validate bucket name
 	for _, prohibitedName := range prohibitedBucketNames {
 		if prohibitedName == bucketName {
 			return ErrProhibitedBucketName
 		}
 	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
 	// Name existence check
-	if _, exists := bucketMap[bucketName]; exists {
+	if _, exists := store.buckets[bucketName]; exists {
 		return ErrBucketAlreadyExists
 	}
 
-	// Create bucket directory
-	err := os.Mkdir(filepath.Join(storagePath, bucketName), 0o755)
+	// Create bucket storage
+	transactionID, err := journal.begin(bucketEntry(journalCreateBucket, bucketName))
 	if err != nil {
-		if os.IsExist(err) {
-			log.Print("bucket directory already exists: " + filepath.Join(storagePath, bucketName))
-		} else {
-			log.Fatal(err)
-		}
-	} else {
-		log.Print("bucket directory created: " + filepath.Join(storagePath, bucketName))
+		return err
 	}
-
-	// Create bucket metadata file
-	objectsMetadataPath := filepath.Join(storagePath, bucketName, "objects.csv")
-	_, err = os.Create(objectsMetadataPath)
+	defer journal.abort(transactionID)
+	err = backend.CreateBucket(bucketName)
 	if err != nil {
-		if os.IsExist(err) {
-			log.Print("bucket metadata file already exists: " + objectsMetadataPath)
-		} else {
-			log.Fatal(err)
-		}
-	} else {
-		log.Print("bucket metadata file created: " + objectsMetadataPath)
+		return err
 	}
 
 	// Bucket add to map
-	if len(bucketMap) == 0 {
-		bucketMap = make(map[string]*bucketData)
-	}
-
-	bucketMap[bucketName] = &bucketData{
+	bucket := &bucketData{
 		Name:             bucketName,
 		CreatedTime:      time.Now().Format(time.RFC822),
 		LastModifiedTime: time.Now().Format(time.RFC822),
 		Status:           "inactive",
-		objects:          &[]bucketObject{},
+		acl:              acl,
+		objects:          newObjectIndex(nil),
+		versions:         map[string][]bucketObject{},
 	}
+	store.buckets[bucketName] = bucket
 
 	// write to csv file
-	bucketsMetadataPath := filepath.Join(storagePath, "buckets.csv")
-	csvFile, err := os.OpenFile(bucketsMetadataPath, os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
-		return err
-	}
-	defer csvFile.Close()
-
-	// write new bucket to csv file
-	csvWriter := csv.NewWriter(csvFile)
-	csvWriter.Write([]string{bucketName, bucketMap[bucketName].CreatedTime, bucketMap[bucketName].LastModifiedTime, bucketMap[bucketName].Status})
-	csvWriter.Flush()
-	err = csvWriter.Error()
+	err = saveBucketsData()
 	if err != nil {
 		return err
 	}
+	journal.end(transactionID)
 
 	log.Print(bucketName + " empty bucket created")
 	return nil
 }
 
-// DELETE handler
-func deleteBucket(bucketName string) (err error) {
-	if _, exists := bucketMap[bucketName]; !exists {
+// HEAD handler
+func headBucket(bucketName string) error {
+	if _, exists := store.get(bucketName); !exists {
 		return ErrBucketNotExists
 	}
+	return nil
+}
 
-	// Remove the bucket's directory
-	bucketPath := filepath.Join(storagePath, bucketName)
-	bucketDir, err := os.ReadDir(bucketPath)
+// DELETE handler
+func deleteBucket(bucketName string) (err error) {
+	bucket, err := store.lockBucket(bucketName)
 	if err != nil {
-		return fmt.Errorf("error while reading the <%s>  directory: %w", bucketPath, err)
-	}
-	if len(bucketDir) <= 1 {
-		if len(bucketDir) == 1 {
-			if bucketDir[0].Name() == "objects.csv" {
-				// Remove the bucket's metadata
-				bucketMetadataPath := filepath.Join(storagePath, bucketName, "objects.csv")
-				err = os.Remove(bucketMetadataPath)
-				if err != nil {
-					return fmt.Errorf("error while deleting metadata file in <%s> bucket: %w", bucketName, err)
-				}
-				err = os.Remove(bucketPath)
-				if err != nil {
-					return fmt.Errorf("error while removing <%s> bucket directory: %w", bucketName, err)
-				}
-			} else {
-				return ErrBucketIsNotEmpty
-			}
-		}
-	} else {
+		return err
+	}
+	defer bucket.mu.Unlock()
+
+	// Only staged multipart uploads may be left in the bucket
+	if bucket.objects.len() > 0 || len(bucket.versions) > 0 {
 		return ErrBucketIsNotEmpty
 	}
+
+	transactionID, err := journal.begin(bucketEntry(journalDeleteBucket, bucketName))
 	if err != nil {
-		if os.IsNotExist(err) {
-			err = nil
-		} else {
-			return err
-		}
+		return err
 	}
+	defer journal.abort(transactionID)
 
-	// Remove the bucket's metadata
-	bucketMetadataPath := filepath.Join(storagePath, bucketName, "objects.csv")
-	err = os.Remove(bucketMetadataPath)
+	// Abort the multipart uploads in progress
+	err = abortBucketMultipartUploads(bucketName)
 	if err != nil {
-		if os.IsNotExist(err) {
-			err = nil
-		} else {
-			return err
-		}
+		return err
+	}
+
+	// Remove the bucket's storage
+	err = backend.DeleteBucket(bucketName)
+	if err != nil {
+		return err
 	}
 
 	// Delete the bucket from the map
-	delete(bucketMap, bucketName)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.buckets, bucketName)
+	bucket.deleted = true
 
 	err = saveBucketsData()
 	if err != nil {
 		return fmt.Errorf("error while saving buckets metadata: %w", err)
 	}
+	journal.end(transactionID)
 
 	log.Print("<" + bucketName + "> bucket deleted")
 	return nil
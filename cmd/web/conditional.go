@@ -0,0 +1,121 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrPreconditionFailed = errors.New("at least one of the preconditions you specified did not hold")
+	ErrNotModified        = errors.New("the object was not modified since the specified time or matches the specified ETag")
+)
+
+// Quotes the stored ETag as it is sent in headers and XML documents
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+// Reports whether the ETag matches the If-Match or If-None-Match header value,
+// which is either "*" or a comma separated list of entity tags
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		// Weak validators compare equal to strong ones, the objects are never transformed
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if etag != "" && strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the object was modified after the time in the header.
+// HTTP dates have a precision of one second
+func modifiedSince(header string, object bucketObject) (modified bool, ok bool) {
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false, false
+	}
+	lastModified, err := parseObjectTime(object.lastModified)
+	if err != nil {
+		return false, false
+	}
+	return lastModified.Truncate(time.Second).After(since), true
+}
+
+// Evaluates the conditional headers of GET and HEAD requests against the object in the RFC 7232 order.
+// Returns ErrPreconditionFailed when If-Match or If-Unmodified-Since do not hold
+// and ErrNotModified when If-None-Match or If-Modified-Since do not hold
+func checkPreconditions(r *http.Request, object bucketObject) error {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, object.etag) {
+			return ErrPreconditionFailed
+		}
+	} else if ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since"); ifUnmodifiedSince != "" {
+		if modified, ok := modifiedSince(ifUnmodifiedSince, object); ok && modified {
+			return ErrPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, object.etag) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return ErrNotModified
+			}
+			return ErrPreconditionFailed
+		}
+	} else if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if modified, ok := modifiedSince(ifModifiedSince, object); ok && !modified {
+				return ErrNotModified
+			}
+		}
+	}
+
+	return nil
+}
+
+// Evaluates the conditional headers of PUT requests against the current object if it exists,
+// the caller must hold the bucket lock.
+// "If-None-Match: *" makes the write create-only and returns ErrObjectAlreadyExists for existing objects
+func checkWritePreconditions(r *http.Request, bucket *bucketData, objectName string) error {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+
+	object, err := findObject(bucket, objectName)
+	exists := err == nil
+
+	if ifNoneMatch != "" && exists && etagMatches(ifNoneMatch, object.etag) {
+		if strings.TrimSpace(ifNoneMatch) == "*" {
+			return ErrObjectAlreadyExists
+		}
+		return ErrPreconditionFailed
+	}
+	if ifMatch != "" {
+		if !exists {
+			return ErrObjectNotExists
+		}
+		if !etagMatches(ifMatch, object.etag) {
+			return ErrPreconditionFailed
+		}
+	}
+	return nil
+}
+
+// Evaluates the conditional headers of PUT requests under the bucket lock
+func checkObjectWritePreconditions(r *http.Request, bucketName, objectName string) error {
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.mu.RUnlock()
+	return checkWritePreconditions(r, bucket, objectName)
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrInvalidCopySource        = errors.New("copy source must be of the form <bucket>/<key>")
+	ErrInvalidMetadataDirective = errors.New("unknown metadata directive, must be COPY or REPLACE")
+	ErrCopyToItself             = errors.New("this copy request is illegal because it is trying to copy an object to itself without changing the object's metadata")
+)
+
+// CopyObject is limited to 5GB sources like in S3
+const maxCopyObjectSize = 5 * bytesIn1gb
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+// PUT with x-amz-copy-source handler
+func copyObject(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	// Names validation
+	for _, prohibitedName := range prohibitedObjectNames {
+		if prohibitedName == objectName {
+			return ErrProhibitedObjectName
+		}
+	}
+	if _, exists := store.get(bucketName); !exists {
+		return ErrBucketNotExists
+	}
+
+	sourceBucketName, sourceObjectName, sourceVersionID, err := parseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return err
+	}
+	sourceAction := "s3:GetObject"
+	if sourceVersionID != "" {
+		sourceAction = "s3:GetObjectVersion"
+	}
+	err = authorize(r, sourceAction, sourceBucketName, sourceObjectName, sourceVersionID)
+	if err != nil {
+		return err
+	}
+	source, sourceReader, err := openObject(sourceBucketName, sourceObjectName, sourceVersionID)
+	if err != nil {
+		return err
+	}
+	defer sourceReader.Close()
+	if source.contentLength > maxCopyObjectSize {
+		return ErrTooBigObject
+	}
+
+	// Source conditions, all of them fail with 412
+	err = checkCopySourcePreconditions(r, source)
+	if err != nil {
+		return err
+	}
+
+	// Metadata is taken either from the source or from the request
+	contentType, metadata := source.contentType, source.metadata
+	switch directive := r.Header.Get("X-Amz-Metadata-Directive"); directive {
+	case "", "COPY":
+		if sourceBucketName == bucketName && sourceObjectName == objectName && sourceVersionID == "" {
+			return ErrCopyToItself
+		}
+	case "REPLACE":
+		metadata, err = objectMetadataFromRequest(r.Header)
+		if err != nil {
+			return err
+		}
+		contentType = r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = source.contentType
+		}
+	default:
+		return ErrInvalidMetadataDirective
+	}
+
+	// Tags are taken either from the source or from the request as well
+	tags := source.tags
+	switch directive := r.Header.Get("X-Amz-Tagging-Directive"); directive {
+	case "", "COPY":
+	case "REPLACE":
+		tags, err = tagsFromRequest(r)
+		if err != nil {
+			return err
+		}
+	default:
+		return ErrInvalidTagDirective
+	}
+
+	// Copy is staged without holding any bucket lock, the source may be the destination itself
+	objectWriter, err := backend.CreateObject(bucketName, objectName)
+	if err != nil {
+		return fmt.Errorf("error while creating copy of <%s> object in <%s> bucket: %w", objectName, bucketName, err)
+	}
+	defer objectWriter.Abort()
+
+	hasher := md5.New()
+	written, err := io.Copy(io.MultiWriter(objectWriter, hasher), sourceReader)
+	if err == nil {
+		err = objectWriter.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error while copying <%s> object in <%s> bucket: %w", sourceObjectName, sourceBucketName, err)
+	}
+
+	// Destination is replaced under its bucket lock
+	bucket, err := store.lockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.mu.Unlock()
+
+	// Destination conditions
+	err = checkWritePreconditions(r, bucket, objectName)
+	if err != nil {
+		return err
+	}
+	object := bucketObject{
+		objectKey:     objectName,
+		contentLength: int(written),
+		contentType:   contentType,
+		lastModified:  time.Now().UTC().Format(time.RFC3339),
+		etag:          hex.EncodeToString(hasher.Sum(nil)),
+		metadata:      metadata,
+		tags:          tags,
+	}
+	err = commitObject(bucket, objectWriter, &object)
+	if err != nil {
+		return err
+	}
+	if object.versionID != "" {
+		w.Header().Set("X-Amz-Version-Id", object.versionID)
+	}
+	if source.versionID != "" {
+		w.Header().Set("X-Amz-Copy-Source-Version-Id", source.versionID)
+	}
+
+	marshalledObject, err := xml.MarshalIndent(copyObjectResult{
+		ETag:         quoteETag(object.etag),
+		LastModified: formatListTime(object.lastModified),
+	}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the copy result of <%s> object: %w", objectName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	log.Printf("<%s> object in <%s> bucket copied to <%s> object in <%s> bucket", sourceObjectName, sourceBucketName, objectName, bucketName)
+	return nil
+}
+
+// Parses the URL encoded "[/]<bucket>/<key>[?versionId=<id>]" copy source
+func parseCopySource(copySource string) (string, string, string, error) {
+	copySource, rawQuery, _ := strings.Cut(copySource, "?")
+	copySource, err := url.PathUnescape(copySource)
+	if err != nil {
+		return "", "", "", ErrInvalidCopySource
+	}
+	sourceBucketName, sourceObjectName, found := strings.Cut(strings.TrimPrefix(copySource, "/"), "/")
+	if !found {
+		return "", "", "", ErrInvalidCopySource
+	}
+	if validateBucketName(sourceBucketName) != nil || validateObjectKey(sourceObjectName) != nil {
+		return "", "", "", ErrInvalidCopySource
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", "", "", ErrInvalidCopySource
+	}
+	return sourceBucketName, sourceObjectName, query.Get("versionId"), nil
+}
+
+// Evaluates x-amz-copy-source-if-* headers against the source object,
+// unlike the plain conditional headers each of them fails with ErrPreconditionFailed
+func checkCopySourcePreconditions(r *http.Request, source bucketObject) error {
+	conditions := &http.Request{Method: http.MethodGet, Header: http.Header{}}
+	for _, name := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if value := r.Header.Get("X-Amz-Copy-Source-" + name); value != "" {
+			conditions.Header.Set(name, value)
+		}
+	}
+	err := checkPreconditions(conditions, source)
+	if err == ErrNotModified {
+		return ErrPreconditionFailed
+	}
+	return err
+}
@@ -0,0 +1,272 @@
+package web
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Errors
+var (
+	ErrNoSuchCORSConfiguration = errors.New("the CORS configuration does not exist")
+	ErrInvalidCORSMethod       = errors.New("CORS rule allows an unsupported HTTP method")
+	ErrInvalidCORSWildcard     = errors.New("CORS rule origins and headers cannot have more than one wildcard")
+	ErrInvalidCORSMaxAge       = errors.New("CORS rule max age must not be negative")
+	ErrMissingCORSHeaders      = errors.New("insufficient information, Origin and Access-Control-Request-Method request headers are needed")
+	ErrCORSNotAllowed          = errors.New("CORS request is not allowed by the CORS configuration of the bucket")
+)
+
+// Maximum number of rules in one CORS configuration
+const maxCORSRules = 100
+
+// Methods the CORS rules may allow
+var corsMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPut:    true,
+	http.MethodPost:   true,
+	http.MethodDelete: true,
+	http.MethodHead:   true,
+}
+
+type corsConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	Rules   []corsRule `xml:"CORSRule"`
+}
+
+type corsRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedHeaders []string `xml:"AllowedHeader"`
+	ExposeHeaders  []string `xml:"ExposeHeader"`
+	MaxAgeSeconds  *int     `xml:"MaxAgeSeconds"`
+}
+
+// Parses and validates the CORS configuration
+func parseCORS(data []byte) (*corsConfiguration, error) {
+	cors := &corsConfiguration{}
+	err := xml.Unmarshal(data, cors)
+	if err != nil || len(cors.Rules) == 0 || len(cors.Rules) > maxCORSRules {
+		return nil, ErrMalformedXML
+	}
+	for _, rule := range cors.Rules {
+		if len(rule.AllowedMethods) == 0 || len(rule.AllowedOrigins) == 0 {
+			return nil, ErrMalformedXML
+		}
+		for _, method := range rule.AllowedMethods {
+			if !corsMethods[method] {
+				return nil, ErrInvalidCORSMethod
+			}
+		}
+		for _, pattern := range append(append([]string{}, rule.AllowedOrigins...), rule.AllowedHeaders...) {
+			if strings.Count(pattern, "*") > 1 {
+				return nil, ErrInvalidCORSWildcard
+			}
+		}
+		if rule.MaxAgeSeconds != nil && *rule.MaxAgeSeconds < 0 {
+			return nil, ErrInvalidCORSMaxAge
+		}
+	}
+	return cors, nil
+}
+
+// Returns the allowed origin matching the origin, empty when there is none
+func (rule corsRule) matchOrigin(origin string) string {
+	for _, allowed := range rule.AllowedOrigins {
+		if wildcardMatch(allowed, origin) {
+			return allowed
+		}
+	}
+	return ""
+}
+
+// Header names are compared case insensitively
+func (rule corsRule) allowsHeader(header string) bool {
+	for _, allowed := range rule.AllowedHeaders {
+		if wildcardMatch(strings.ToLower(allowed), strings.ToLower(header)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the first rule allowing the origin, the method and all of the headers
+func (cors *corsConfiguration) match(origin, method string, headers []string) (corsRule, string, bool) {
+rules:
+	for _, rule := range cors.Rules {
+		allowedOrigin := rule.matchOrigin(origin)
+		if allowedOrigin == "" || !containsString(rule.AllowedMethods, method) {
+			continue
+		}
+		for _, header := range headers {
+			if !rule.allowsHeader(header) {
+				continue rules
+			}
+		}
+		return rule, allowedOrigin, true
+	}
+	return corsRule{}, "", false
+}
+
+// Sets the headers common to the preflight and the actual responses of the matched rule.
+// A rule allowing any origin answers with the wildcard, the others echo the origin and allow credentials
+func writeCORSHeaders(w http.ResponseWriter, rule corsRule, allowedOrigin, origin string) {
+	if allowedOrigin == "*" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+}
+
+// Returns the CORS configuration of the bucket, nil when there is none
+func bucketCORS(bucketName string) (*corsConfiguration, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	bucket, exists := store.buckets[bucketName]
+	if !exists {
+		return nil, ErrBucketNotExists
+	}
+	return bucket.cors, nil
+}
+
+// Adds the CORS headers to the response of an actual request with the Origin header
+// when a rule of the bucket allows it, the request itself is never rejected here
+func applyCORS(w http.ResponseWriter, r *http.Request, bucketName string) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	cors, err := bucketCORS(bucketName)
+	if err != nil || cors == nil {
+		return
+	}
+	w.Header().Add("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+	if rule, allowedOrigin, ok := cors.match(origin, r.Method, nil); ok {
+		writeCORSHeaders(w, rule, allowedOrigin, origin)
+	}
+}
+
+// OPTIONS /<bucket> and /<bucket>/<key> preflight handler, it is not authenticated
+// as browsers never sign the preflight requests
+func preflightCORS(w http.ResponseWriter, r *http.Request, bucketName string) error {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if origin == "" || method == "" {
+		return ErrMissingCORSHeaders
+	}
+	headers := []string{}
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, strings.ToLower(header))
+		}
+	}
+
+	cors, err := bucketCORS(bucketName)
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+	if cors == nil {
+		return ErrCORSNotAllowed
+	}
+	rule, allowedOrigin, ok := cors.match(origin, method, headers)
+	if !ok {
+		return ErrCORSNotAllowed
+	}
+	writeCORSHeaders(w, rule, allowedOrigin, origin)
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// GET /<bucket>?cors handler
+func getBucketCORS(w http.ResponseWriter, bucketName string) error {
+	cors, err := bucketCORS(bucketName)
+	if err != nil {
+		return err
+	} else if cors == nil {
+		return ErrNoSuchCORSConfiguration
+	}
+	marshalledObject, err := xml.MarshalIndent(cors, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the CORS configuration of <%s> bucket: %w", bucketName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+// PUT /<bucket>?cors handler, the configuration replaces the previous one
+func putBucketCORS(r *http.Request, bucketName string) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, bytesIn1mb))
+	if err != nil {
+		return fmt.Errorf("error while reading the CORS configuration of <%s> bucket: %w", bucketName, err)
+	}
+	err = checkContentMD5(r, body)
+	if err != nil {
+		return err
+	}
+	cors, err := parseCORS(body)
+	if err != nil {
+		return err
+	}
+	// Configuration is stored in its canonical form
+	corsXML, err := xml.Marshal(cors)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the CORS configuration of <%s> bucket: %w", bucketName, err)
+	}
+	err = setBucketCORS(bucketName, string(corsXML), cors)
+	if err != nil {
+		return err
+	}
+	log.Printf("CORS configuration of <%s> bucket updated", bucketName)
+	return nil
+}
+
+// DELETE /<bucket>?cors handler
+func deleteBucketCORS(bucketName string) error {
+	err := setBucketCORS(bucketName, "", nil)
+	if err != nil {
+		return err
+	}
+	log.Printf("CORS configuration of <%s> bucket deleted", bucketName)
+	return nil
+}
+
+// Replaces the CORS configuration of the bucket and persists the buckets metadata
+func setBucketCORS(bucketName, corsXML string, cors *corsConfiguration) error {
+	return setBucketConfig(bucketName, func(bucket *bucketData) func() {
+		previousXML, previous := bucket.corsXML, bucket.cors
+		bucket.corsXML, bucket.cors = corsXML, cors
+		return func() {
+			bucket.corsXML, bucket.cors = previousXML, previous
+		}
+	})
+}
+
+// Parses the stored CORS configuration, an empty configuration is no configuration
+func loadBucketCORS(bucket *bucketData) error {
+	if bucket.corsXML == "" {
+		return nil
+	}
+	cors, err := parseCORS([]byte(bucket.corsXML))
+	if err != nil {
+		return fmt.Errorf("error while parsing CORS configuration of <%s> bucket: %w", bucket.Name, err)
+	}
+	bucket.cors = cors
+	return nil
+}
@@ -0,0 +1,175 @@
+package web
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Errors
+var (
+	ErrBadDigest = errors.New("the Content-MD5 you specified did not match what was received")
+)
+
+// Maximum number of keys in one DeleteObjects request
+const maxDeleteObjects = 1000
+
+type deleteObjectsRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool     `xml:"Quiet"`
+	Objects []struct {
+		Key       string `xml:"Key"`
+		VersionID string `xml:"VersionId"`
+	} `xml:"Object"`
+}
+
+type deleteObjectsResult struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+type deletedObject struct {
+	Key                   string `xml:"Key"`
+	VersionID             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// Checks the body against the Content-MD5 header when it is given
+func checkContentMD5(r *http.Request, body []byte) error {
+	contentMD5 := r.Header.Get("Content-MD5")
+	if contentMD5 == "" {
+		return nil
+	}
+	digest := md5.Sum(body)
+	if contentMD5 != base64.StdEncoding.EncodeToString(digest[:]) {
+		return ErrBadDigest
+	}
+	return nil
+}
+
+// POST /{bucket}?delete handler, the bucket metadata is persisted once for the whole batch.
+// Keys of versioned buckets and given versions are deleted one by one like by the DELETE handler
+func deleteObjects(w http.ResponseWriter, r *http.Request, bucketName string) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 2*bytesIn1mb))
+	if err != nil {
+		return fmt.Errorf("error while reading delete request of <%s> bucket: %w", bucketName, err)
+	}
+	err = checkContentMD5(r, body)
+	if err != nil {
+		return err
+	}
+	request := deleteObjectsRequest{}
+	err = xml.Unmarshal(body, &request)
+	if err != nil || len(request.Objects) == 0 || len(request.Objects) > maxDeleteObjects {
+		return ErrMalformedXML
+	}
+
+	// Every key is authorized on its own, denied keys are reported as errors
+	authErrors := make([]error, len(request.Objects))
+	for objectIdx, object := range request.Objects {
+		action := "s3:DeleteObject"
+		if object.VersionID != "" {
+			action = "s3:DeleteObjectVersion"
+		}
+		authErrors[objectIdx] = authorize(r, action, bucketName, object.Key, object.VersionID)
+	}
+
+	bucket, err := store.lockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.mu.Unlock()
+	versioning := bucketVersioning(bucket)
+
+	// Removals of the existing objects are journaled as one transaction
+	entries := []journalEntry{}
+	for objectIdx, object := range request.Objects {
+		if versioning != "" || object.VersionID != "" {
+			continue
+		}
+		if _, exists := bucket.objects.find(object.Key); exists && authErrors[objectIdx] == nil {
+			entries = append(entries, deleteObjectEntry(bucketName, object.Key))
+		}
+	}
+	transactionID, err := journal.begin(entries...)
+	if err != nil {
+		return err
+	}
+	defer journal.abort(transactionID)
+
+	result := deleteObjectsResult{}
+	changes := []objectChange{}
+	for objectIdx, object := range request.Objects {
+		err := authErrors[objectIdx]
+		if err == nil {
+			err = validateObjectKey(object.Key)
+		}
+		if err == nil && (versioning != "" || object.VersionID != "") {
+			version, err := deleteVersion(bucket, versioning, object.Key, object.VersionID)
+			if err == nil || err == ErrNoSuchVersion {
+				if !request.Quiet {
+					result.Deleted = append(result.Deleted, newDeletedVersion(object.Key, object.VersionID, version))
+				}
+				continue
+			}
+			message, code := mapErrorToMessageAndCode(err)
+			result.Errors = append(result.Errors, deleteError{Key: object.Key, Code: code, Message: message})
+			continue
+		}
+		if err == nil {
+			err = removeObject(bucket, object.Key)
+		}
+		switch err {
+		case nil:
+			changes = append(changes, removedObjectChange(object.Key))
+			fallthrough
+		case ErrObjectNotExists:
+			// Deleting a missing key succeeds like in S3
+			if !request.Quiet {
+				result.Deleted = append(result.Deleted, deletedObject{Key: object.Key, VersionID: object.VersionID})
+			}
+		default:
+			message, code := mapErrorToMessageAndCode(err)
+			result.Errors = append(result.Errors, deleteError{Key: object.Key, Code: code, Message: message})
+		}
+	}
+
+	if len(changes) > 0 {
+		err = saveObjectsData(bucket, changes...)
+		if err != nil {
+			return fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucketName, err)
+		}
+	}
+	journal.end(transactionID)
+
+	marshalledObject, err := xml.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the delete result of <%s> bucket: %w", bucketName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	log.Printf("%d objects deleted from <%s> bucket", len(changes), bucketName)
+	return nil
+}
+
+// Result entry of the deleted version, a placed delete marker is reported along with its version ID
+func newDeletedVersion(objectKey, requested string, version bucketObject) deletedObject {
+	deleted := deletedObject{Key: objectKey, VersionID: requested}
+	if version.deleteMarker {
+		deleted.DeleteMarker = true
+		deleted.DeleteMarkerVersionID = displayVersionID(version.versionID)
+	}
+	return deleted
+}
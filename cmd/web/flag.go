@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Errors
@@ -17,6 +18,18 @@ var (
 var (
 	Port        = 4000
 	storagePath = "data"
+	// Port of the static website endpoint, 0 disables it
+	WebsitePort = 0
+	// Multipart uploads initiated longer ago are aborted by the sweeper
+	multipartUploadTTL = 7 * 24 * time.Hour
+	// Interval of re-hashing the stored objects, 0 disables the scrubber
+	scrubInterval time.Duration
+	// File of access key ID and secret access key pairs, requests are not authenticated without it
+	credentialsPath = ""
+	// Interval of applying the lifecycle rules of the buckets, 0 disables the lifecycle worker
+	lifecycleInterval = time.Hour
+	// Lifecycle worker only logs what would expire
+	lifecycleDryRun = false
 )
 
 func Parse(args []string) (err error) {
@@ -39,10 +52,46 @@ func Parse(args []string) (err error) {
 			if err != nil {
 				return fmt.Errorf("error while parsing the port: %w", err)
 			}
+		case "website-port":
+			WebsitePort, err = strconv.Atoi(flagValue)
+			if err != nil {
+				return fmt.Errorf("error while parsing the website port: %w", err)
+			}
 		case "dir":
 			storagePath = flagValue
+		case "upload-ttl":
+			multipartUploadTTL, err = time.ParseDuration(flagValue)
+			if err != nil {
+				return fmt.Errorf("error while parsing the upload ttl: %w", err)
+			} else if multipartUploadTTL <= 0 {
+				return fmt.Errorf("upload ttl must be positive")
+			}
+		case "credentials":
+			credentialsPath = flagValue
+		case "scrub-interval":
+			scrubInterval, err = time.ParseDuration(flagValue)
+			if err != nil {
+				return fmt.Errorf("error while parsing the scrub interval: %w", err)
+			} else if scrubInterval < 0 {
+				return fmt.Errorf("scrub interval must not be negative")
+			}
+		case "lifecycle-interval":
+			lifecycleInterval, err = time.ParseDuration(flagValue)
+			if err != nil {
+				return fmt.Errorf("error while parsing the lifecycle interval: %w", err)
+			} else if lifecycleInterval < 0 {
+				return fmt.Errorf("lifecycle interval must not be negative")
+			}
+		case "lifecycle-dry-run":
+			lifecycleDryRun, err = strconv.ParseBool(flagValue)
+			if err != nil {
+				return fmt.Errorf("error while parsing the lifecycle dry run: %w", err)
+			}
 		}
 	}
+	if WebsitePort != 0 && WebsitePort == Port {
+		return fmt.Errorf("website port must differ from the port")
+	}
 
 	return nil
 }
@@ -51,11 +100,30 @@ func PrintHelp() {
 	fmt.Println("Simple Storage Service.")
 	fmt.Println("")
 	fmt.Println("**Usage:**")
-	fmt.Println("\ttriple-s [--port <N>] [--dir <S>]")
+	fmt.Println("\ttriple-s [--port <N>] [--website-port <N>] [--dir <S>] [--upload-ttl <D>] [--scrub-interval <D>] [--lifecycle-interval <D>] [--lifecycle-dry-run <B>] [--credentials <S>]")
+	fmt.Println("\ttriple-s fsck [--dir <S>] [--repair] [--rehash]")
+	fmt.Println("\ttriple-s presign --credentials <S> --access-key <K> [--endpoint <URL>] [--method <M>] [--expires <D>] <bucket>/<key>")
 	fmt.Println("\ttriple-s --help")
 	fmt.Println("")
 	fmt.Println("**Options:**")
 	fmt.Println("- --help     Show this screen.")
 	fmt.Println("- --port N   Port number")
+	fmt.Println("- --website-port N  Port number of the static website endpoint, buckets are selected by the Host header (default 0, disabled)")
 	fmt.Println("- --dir S    Path to the directory")
+	fmt.Println("- --upload-ttl D  Abort multipart uploads older than D, e.g. 24h (default 168h)")
+	fmt.Println("- --scrub-interval D  Re-hash the stored objects every D and log the problems (default 0, disabled)")
+	fmt.Println("- --lifecycle-interval D  Apply the lifecycle rules of the buckets every D (default 1h, 0 disables)")
+	fmt.Println("- --lifecycle-dry-run B   Only log the objects the lifecycle rules would expire, true or false (default false)")
+	fmt.Println("- --credentials S  CSV file of root access key ID and secret key pairs, requests must be signed with them or with user keys")
+	fmt.Println("                   Root keys are allowed everything and manage the users under /_admin/users")
+	fmt.Println("")
+	fmt.Println("**Fsck options:**")
+	fmt.Println("- --repair   Repair the inconsistencies instead of only reporting them")
+	fmt.Println("- --rehash   Verify the checksums of the stored objects")
+	fmt.Println("")
+	fmt.Println("**Presign options:**")
+	fmt.Println("- --access-key K  Access key ID from the credentials file to sign the URL with")
+	fmt.Println("- --endpoint URL  Address of the server (default http://localhost:4000)")
+	fmt.Println("- --method M      GET or PUT (default GET)")
+	fmt.Println("- --expires D     Lifetime of the URL, at most 168h (default 1h)")
 }
@@ -0,0 +1,563 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fsBackend keeps the storage in a directory:
+//
+//	buckets.csv                      name, created, last modified, status, acl, policy, versioning, lifecycle, cors, website
+//	<bucket>/objects.csv             key, length, content type, last modified, etag, metadata, acl, version id, delete marker, tags
+//	<bucket>/objects.log             changes of the objects metadata made after objects.csv was written
+//	<bucket>/<encoded key>           object content
+//	<bucket>/.multipart/<id>/        upload.csv, parts.csv and the part files of a staged upload
+//	<bucket>/.versions.log           records of the noncurrent versions
+//	<bucket>/.versions/<key>/<id>    contents of the noncurrent versions
+//
+// Files are replaced through temporary files, so a crash never leaves them half-written
+type fsBackend struct {
+	root string
+
+	// mu guards logRecords and versionLogRecords, the number of records in objects.log
+	// and in .versions.log of each bucket
+	mu                sync.Mutex
+	logRecords        map[string]int
+	versionLogRecords map[string]int
+
+	// Temporary files of interrupted writes are left in place for fsck to report them
+	keepTempFiles bool
+}
+
+func newFSBackend(root string) *fsBackend {
+	return &fsBackend{root: root, logRecords: map[string]int{}, versionLogRecords: map[string]int{}}
+}
+
+// Directory inside the bucket where parts are staged, object files never start with a dot
+const multipartDirName = ".multipart"
+
+// Metadata file of the bucket's objects
+const objectsMetadataFileName = "objects.csv"
+
+func (b *fsBackend) bucketPath(bucketName string) string {
+	return filepath.Join(b.root, bucketName)
+}
+
+// Returns the path of the object file in the bucket directory
+func (b *fsBackend) objectPath(bucketName, objectKey string) string {
+	return filepath.Join(b.root, bucketName, encodeObjectKey(objectKey))
+}
+
+// Directory of the upload inside the bucket
+func (b *fsBackend) uploadPath(bucketName, uploadID string) string {
+	return filepath.Join(b.root, bucketName, multipartDirName, uploadID)
+}
+
+func (b *fsBackend) partPath(upload *multipartUpload, partNumber int) string {
+	return filepath.Join(b.uploadPath(upload.bucketName, upload.uploadID), fmt.Sprintf("%05d", partNumber))
+}
+
+// Maximum length of the object file name, most filesystems limit names to 255 bytes
+const maxObjectFileNameLength = 255
+
+// Maps the object key to a flat file name which is safe to use inside the bucket directory.
+// Every byte except ASCII letters, digits, '-', '_' and a non-leading '.' is percent-encoded,
+// so the name never contains path separators, is never "." or ".." and never starts with a dot.
+// Keys which become too long are replaced with their SHA-256 prefixed by '~', which is always
+// escaped in the encoded form. Plain keys like "photo.png" are stored under the same name.
+func encodeObjectKey(objectKey string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var encoded strings.Builder
+	for i := 0; i < len(objectKey); i++ {
+		c := objectKey[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+			encoded.WriteByte(c)
+		case c == '.' && i > 0:
+			encoded.WriteByte(c)
+		default:
+			encoded.WriteByte('%')
+			encoded.WriteByte(hexDigits[c>>4])
+			encoded.WriteByte(hexDigits[c&15])
+		}
+	}
+	if encoded.Len() > maxObjectFileNameLength {
+		return fmt.Sprintf("~%x", sha256.Sum256([]byte(objectKey)))
+	}
+	return encoded.String()
+}
+
+// Load buckets from data path if exist
+// if doesn't exist, creates new buckets.csv
+func (b *fsBackend) LoadBuckets() ([]*bucketData, error) {
+	// Validate storage path
+	for _, prohibitedPath := range ProhibitedStoragePaths {
+		if prohibitedPath == strings.Trim(b.root, "/") {
+			return nil, ErrProhibitedStoragePath
+		}
+	}
+
+	// Create storage directory if not exists
+	err := os.Mkdir(b.root, 0o755)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("error while creating storage directory: %w", err)
+		}
+	} else {
+		log.Print("created storage directory: " + b.root)
+	}
+
+	// Temporary files of interrupted writes
+	if !b.keepTempFiles {
+		err = removeTempFiles(b.root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Opening buckets.csv metadata file
+	bucketsMetadataPath := filepath.Join(b.root, "buckets.csv")
+	bucketsFile, err := os.OpenFile(bucketsMetadataPath, os.O_RDONLY, 0o644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			bucketsFile, err := os.OpenFile(bucketsMetadataPath, os.O_CREATE, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("error while creating bucket metadata file: %w", err)
+			}
+			bucketsFile.Close()
+			log.Print("created buckets.csv metadata file")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error while opening bucket metadata file: %w", err)
+	}
+	defer bucketsFile.Close()
+
+	// Validation must be in router, so here it is skipped
+	// so here bucketName is not validated
+
+	// Parsing buckets.csv file
+	bucketsCsvReader := csv.NewReader(bucketsFile)
+	bucketsCsvReader.FieldsPerRecord = -1
+
+	// Iterate over csv records
+	buckets := []*bucketData{}
+	for {
+		bucketsRecord, err := bucketsCsvReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				return buckets, nil
+			}
+			return nil, fmt.Errorf("error while reading buckets' metadata: %w", err)
+			// csv record length validation
+			// Older records have no ACL, policy, versioning, lifecycle, CORS and website
+		} else if len(bucketsRecord) < 4 || len(bucketsRecord) > 10 {
+			return nil, ErrInvalidNumberOfFields
+		}
+
+		// Bucket without a directory is kept empty for fsck to report it
+		objects := newObjectIndex(nil)
+		versions := map[string][]bucketObject{}
+		if _, err := os.Stat(b.bucketPath(bucketsRecord[0])); err == nil {
+			if !b.keepTempFiles {
+				err = removeTempFiles(b.bucketPath(bucketsRecord[0]))
+				if err != nil {
+					return nil, err
+				}
+			}
+			objects, err = b.loadObjects(bucketsRecord[0])
+			if err != nil {
+				return nil, err
+			}
+			versions, err = b.loadVersions(bucketsRecord[0])
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			log.Printf("directory of <%s> bucket is missing: %s", bucketsRecord[0], err)
+		}
+
+		bucket := &bucketData{
+			Name:             bucketsRecord[0],
+			CreatedTime:      bucketsRecord[1],
+			LastModifiedTime: bucketsRecord[2],
+			Status:           bucketsRecord[3],
+			objects:          objects,
+			versions:         versions,
+		}
+		if len(bucketsRecord) > 4 {
+			bucket.acl = bucketsRecord[4]
+		}
+		if len(bucketsRecord) > 5 {
+			bucket.policyJSON = bucketsRecord[5]
+		}
+		if len(bucketsRecord) > 6 {
+			bucket.versioning = bucketsRecord[6]
+		}
+		if len(bucketsRecord) > 7 {
+			bucket.lifecycleXML = bucketsRecord[7]
+		}
+		if len(bucketsRecord) > 8 {
+			bucket.corsXML = bucketsRecord[8]
+		}
+		if len(bucketsRecord) > 9 {
+			bucket.websiteXML = bucketsRecord[9]
+		}
+		buckets = append(buckets, bucket)
+	}
+}
+
+// Rewrites buckets.csv
+func (b *fsBackend) SaveBuckets(buckets []*bucketData) error {
+	bucketsMetadataPath := filepath.Join(b.root, "buckets.csv")
+	return writeFileAtomic(bucketsMetadataPath, func(w io.Writer) error {
+		csvWriter := csv.NewWriter(w)
+		for _, bucket := range buckets {
+			status, lastModified := bucket.stats()
+			err := csvWriter.Write([]string{bucket.Name, bucket.CreatedTime, lastModified, status, bucket.acl, bucket.policyJSON, bucket.versioning, bucket.lifecycleXML, bucket.corsXML, bucket.websiteXML})
+			if err != nil {
+				return fmt.Errorf("error while saving bucket's metadata to buckets.csv file")
+			}
+		}
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return fmt.Errorf("error while saving bucket's metadata to buckets.csv file: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *fsBackend) CreateBucket(bucketName string) error {
+	// Create bucket directory
+	bucketPath := b.bucketPath(bucketName)
+	err := os.Mkdir(bucketPath, 0o755)
+	if err != nil {
+		if !os.IsExist(err) {
+			return fmt.Errorf("error while creating <%s> bucket directory: %w", bucketName, err)
+		}
+		log.Print("bucket directory already exists: " + bucketPath)
+	} else {
+		log.Print("bucket directory created: " + bucketPath)
+	}
+
+	// Create bucket metadata file, a log left by a previous bucket of the same name is dropped
+	objectsMetadataPath := filepath.Join(bucketPath, objectsMetadataFileName)
+	err = writeFileAtomic(objectsMetadataPath, func(w io.Writer) error { return nil })
+	if err != nil {
+		return fmt.Errorf("error while creating <%s> bucket metadata file: %w", bucketName, err)
+	}
+	err = b.removeObjectsLog(bucketName)
+	if err == nil {
+		err = b.removeVersionsLog(bucketName)
+	}
+	if err != nil {
+		return err
+	}
+	log.Print("bucket metadata file created: " + objectsMetadataPath)
+	return nil
+}
+
+func (b *fsBackend) DeleteBucket(bucketName string) error {
+	bucketPath := b.bucketPath(bucketName)
+	bucketDir, err := os.ReadDir(bucketPath)
+	if err != nil {
+		return fmt.Errorf("error while reading the <%s>  directory: %w", bucketPath, err)
+	}
+	// Only the bucket's metadata, staged multipart uploads and emptied version directories may be left
+	for _, entry := range bucketDir {
+		switch entry.Name() {
+		case objectsMetadataFileName, objectsLogFileName, multipartDirName, versionsLogFileName:
+		case versionsDirName:
+			hasVersions, err := b.hasVersionContents(bucketName)
+			if err != nil {
+				return fmt.Errorf("error while reading <%s> bucket versions directory: %w", bucketName, err)
+			} else if hasVersions {
+				return ErrBucketIsNotEmpty
+			}
+		default:
+			return ErrBucketIsNotEmpty
+		}
+	}
+
+	err = os.RemoveAll(filepath.Join(bucketPath, multipartDirName))
+	if err != nil {
+		return fmt.Errorf("error while removing <%s> bucket multipart directory: %w", bucketName, err)
+	}
+	err = os.RemoveAll(filepath.Join(bucketPath, versionsDirName))
+	if err != nil {
+		return fmt.Errorf("error while removing <%s> bucket versions directory: %w", bucketName, err)
+	}
+	err = b.removeVersionsLog(bucketName)
+	if err != nil {
+		return err
+	}
+	// Remove the bucket's metadata
+	err = b.removeObjectsLog(bucketName)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(bucketPath, objectsMetadataFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error while deleting metadata file in <%s> bucket: %w", bucketName, err)
+	}
+	err = os.Remove(bucketPath)
+	if err != nil {
+		return fmt.Errorf("error while removing <%s> bucket directory: %w", bucketName, err)
+	}
+	return nil
+}
+
+func (b *fsBackend) OpenObject(bucketName, objectKey string) (ObjectReader, error) {
+	return openFileReader(b.objectPath(bucketName, objectKey))
+}
+
+func (b *fsBackend) CreateObject(bucketName, objectKey string) (ObjectWriter, error) {
+	return newFileWriter(b.bucketPath(bucketName), b.objectPath(bucketName, objectKey))
+}
+
+func (b *fsBackend) RemoveObject(bucketName, objectKey string) error {
+	err := os.Remove(b.objectPath(bucketName, objectKey))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Loads the staged uploads of the bucket, uploads with unreadable metadata are skipped
+func (b *fsBackend) LoadUploads(bucketName string) ([]*multipartUpload, error) {
+	multipartPath := filepath.Join(b.bucketPath(bucketName), multipartDirName)
+	entries, err := os.ReadDir(multipartPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error while reading <%s> multipart directory: %w", multipartPath, err)
+	}
+
+	uploads := []*multipartUpload{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		err = removeTempFiles(filepath.Join(multipartPath, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		upload, err := b.loadUpload(bucketName, entry.Name())
+		if err != nil {
+			log.Printf("skipped <%s> multipart upload in <%s> bucket: %s", entry.Name(), bucketName, err)
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+func (b *fsBackend) loadUpload(bucketName, uploadID string) (*multipartUpload, error) {
+	uploadPath := b.uploadPath(bucketName, uploadID)
+	uploadFile, err := os.Open(filepath.Join(uploadPath, "upload.csv"))
+	if err != nil {
+		return nil, err
+	}
+	defer uploadFile.Close()
+	uploadRecord, err := csv.NewReader(uploadFile).Read()
+	if err != nil {
+		return nil, err
+	} else if len(uploadRecord) < 3 || len(uploadRecord) > 6 {
+		return nil, ErrInvalidNumberOfFields
+	}
+	metadata := map[string]string{}
+	if len(uploadRecord) > 3 {
+		metadata, err = decodeObjectMetadata(uploadRecord[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+	var tags map[string]string
+	if len(uploadRecord) > 4 {
+		tags, err = decodeObjectTags(uploadRecord[4])
+		if err != nil {
+			return nil, err
+		}
+	}
+	acl := ""
+	if len(uploadRecord) > 5 {
+		acl = uploadRecord[5]
+	}
+	upload := &multipartUpload{
+		uploadID:    uploadID,
+		bucketName:  bucketName,
+		objectKey:   uploadRecord[0],
+		initiated:   uploadRecord[1],
+		contentType: uploadRecord[2],
+		metadata:    metadata,
+		tags:        tags,
+		acl:         acl,
+		parts:       map[int]uploadPart{},
+	}
+
+	partsFile, err := os.Open(filepath.Join(uploadPath, "parts.csv"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return upload, nil
+		}
+		return nil, err
+	}
+	defer partsFile.Close()
+	partsCsvReader := csv.NewReader(partsFile)
+	partsCsvReader.FieldsPerRecord = -1
+	for {
+		// Records are appended, only the last one may be torn by a crash and it is dropped
+		partRecord, err := partsCsvReader.Read()
+		if err != nil || len(partRecord) != 4 {
+			break
+		}
+		partNumber, err := strconv.Atoi(partRecord[0])
+		if err != nil {
+			break
+		}
+		size, err := strconv.Atoi(partRecord[1])
+		if err != nil {
+			break
+		}
+		upload.parts[partNumber] = uploadPart{
+			partNumber:   partNumber,
+			size:         size,
+			etag:         partRecord[2],
+			lastModified: partRecord[3],
+		}
+	}
+
+	// Parts whose file does not match the record were interrupted
+	for partNumber, part := range upload.parts {
+		fileInfo, err := os.Stat(b.partPath(upload, partNumber))
+		if err != nil || fileInfo.Size() != int64(part.size) {
+			delete(upload.parts, partNumber)
+		}
+	}
+	return upload, nil
+}
+
+// Creates the staging directory with the upload description
+func (b *fsBackend) CreateUpload(upload *multipartUpload) error {
+	uploadPath := b.uploadPath(upload.bucketName, upload.uploadID)
+	err := os.MkdirAll(uploadPath, 0o755)
+	if err != nil {
+		return fmt.Errorf("error while creating <%s> upload directory: %w", uploadPath, err)
+	}
+	return writeFileAtomic(filepath.Join(uploadPath, "upload.csv"), func(w io.Writer) error {
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Write([]string{upload.objectKey, upload.initiated, upload.contentType, encodeObjectMetadata(upload.metadata), encodeObjectTags(upload.tags), upload.acl})
+		csvWriter.Flush()
+		return csvWriter.Error()
+	})
+}
+
+func (b *fsBackend) CreatePart(upload *multipartUpload, partNumber int) (ObjectWriter, error) {
+	return newFileWriter(b.uploadPath(upload.bucketName, upload.uploadID), b.partPath(upload, partNumber))
+}
+
+// Parts metadata is appended, the last record of the part number wins
+func (b *fsBackend) SavePart(upload *multipartUpload, part uploadPart) error {
+	partsMetadataPath := filepath.Join(b.uploadPath(upload.bucketName, upload.uploadID), "parts.csv")
+	partsFile, err := os.OpenFile(partsMetadataPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error while opening <%s> upload parts metadata file: %w", upload.uploadID, err)
+	}
+	defer partsFile.Close()
+
+	csvWriter := csv.NewWriter(partsFile)
+	csvWriter.Write([]string{strconv.Itoa(part.partNumber), strconv.Itoa(part.size), part.etag, part.lastModified})
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return fmt.Errorf("error while writing <%s> upload parts metadata file: %w", upload.uploadID, err)
+	}
+	err = partsFile.Sync()
+	if err != nil {
+		return fmt.Errorf("error while flushing <%s> upload parts metadata file: %w", upload.uploadID, err)
+	}
+	return nil
+}
+
+func (b *fsBackend) OpenPart(upload *multipartUpload, partNumber int) (ObjectReader, error) {
+	return openFileReader(b.partPath(upload, partNumber))
+}
+
+func (b *fsBackend) RemoveUpload(upload *multipartUpload) error {
+	return os.RemoveAll(b.uploadPath(upload.bucketName, upload.uploadID))
+}
+
+// fileReader reads a stored file, it stays valid after the file is replaced or removed
+type fileReader struct {
+	*os.File
+	size int64
+}
+
+func openFileReader(path string) (*fileReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileReader{File: file, size: fileInfo.Size()}, nil
+}
+
+func (r *fileReader) Size() int64 {
+	return r.size
+}
+
+// fileWriter writes a temporary file which is renamed over the target on commit
+type fileWriter struct {
+	file   *os.File
+	path   string
+	closed bool
+}
+
+// The temporary file is created in the directory of the target, so that the rename is atomic
+func newFileWriter(dir, path string) (*fileWriter, error) {
+	file, err := createTempFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{file: file, path: path}, nil
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *fileWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return closeTempFile(w.file)
+}
+
+func (w *fileWriter) Commit() error {
+	err := w.Close()
+	if err != nil {
+		return err
+	}
+	return renameTempFile(w.file.Name(), w.path)
+}
+
+func (w *fileWriter) Abort() {
+	if !w.closed {
+		w.closed = true
+		w.file.Close()
+	}
+	// The temporary name is gone after a commit
+	os.Remove(w.file.Name())
+}
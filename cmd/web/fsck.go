@@ -0,0 +1,414 @@
+package web
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrInconsistentStorage = errors.New("storage has inconsistencies which are not repaired")
+)
+
+// Problems found by fsck and the scrubber
+const (
+	problemMissingContent    = "content is missing"
+	problemSizeMismatch      = "content size differs from the metadata"
+	problemChecksumMismatch  = "content does not match its checksum"
+	problemMissingChecksum   = "metadata has no checksum"
+	problemOrphanedContent   = "content has no metadata"
+	problemUnlistedBucket    = "bucket directory is missing from buckets.csv"
+	problemMissingBucket     = "bucket directory is missing"
+	problemUnfinishedJournal = "journal has an unfinished transaction"
+)
+
+type fsckProblem struct {
+	bucketName string
+	// Object key, file name of orphaned content or journal transaction
+	name     string
+	problem  string
+	repaired bool
+}
+
+type fsckReport struct {
+	buckets  int
+	objects  int
+	problems []fsckProblem
+}
+
+func (report *fsckReport) add(bucketName, name, problem string, repaired bool) {
+	report.problems = append(report.problems, fsckProblem{bucketName: bucketName, name: name, problem: problem, repaired: repaired})
+}
+
+// Checks the content of the object against its record and returns the problem, if any, along with
+// the MD5 of the content when it was read. Contents are re-hashed only with rehash or to backfill
+// a missing checksum, multipart ETags are not digests of the content and are only checked by size
+func checkObjectContent(bucketName string, object bucketObject, rehash bool) (string, string, error) {
+	objectReader, err := backend.OpenObject(bucketName, object.objectKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return problemMissingContent, "", nil
+		}
+		return "", "", fmt.Errorf("error while opening <%s> object in <%s> bucket: %w", object.objectKey, bucketName, err)
+	}
+	defer objectReader.Close()
+
+	if objectReader.Size() != int64(object.contentLength) {
+		return problemSizeMismatch, "", nil
+	}
+	multipart := strings.Contains(object.etag, "-")
+	if multipart || (!rehash && object.etag != "") {
+		return "", "", nil
+	}
+
+	hasher := md5.New()
+	_, err = io.Copy(hasher, objectReader)
+	if err != nil {
+		return "", "", fmt.Errorf("error while reading <%s> object in <%s> bucket: %w", object.objectKey, bucketName, err)
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	switch object.etag {
+	case "":
+		return problemMissingChecksum, checksum, nil
+	case checksum:
+		return "", checksum, nil
+	default:
+		return problemChecksumMismatch, checksum, nil
+	}
+}
+
+// Fsck checks the storage directory offline:
+//
+//	triple-s fsck --dir <S> [--repair] [--rehash]
+//
+// Records whose content is missing are dropped, contents and bucket directories without metadata
+// are removed or adopted, temporary files of interrupted writes are removed, missing checksums are backfilled and unfinished journal transactions
+// are recovered only with --repair. Sizes and checksums which do not match are reported only,
+// there is no other copy to restore the content from
+func Fsck(args []string) error {
+	repair, rehash := false, false
+	for argIdx := 0; argIdx < len(args); argIdx++ {
+		switch strings.TrimPrefix(args[argIdx], "--") {
+		case "help":
+			PrintHelp()
+			return ErrHelpCalled
+		case "repair":
+			repair = true
+		case "rehash":
+			rehash = true
+		case "dir":
+			if argIdx+1 == len(args) {
+				return ErrInvalidNumberOfArguments
+			}
+			argIdx++
+			storagePath = args[argIdx]
+		default:
+			return fmt.Errorf("unknown fsck argument: %s", args[argIdx])
+		}
+	}
+
+	// Storage is never created by fsck
+	if _, err := os.Stat(storagePath); err != nil {
+		return fmt.Errorf("error while opening storage directory: %w", err)
+	}
+	fsBackend := newFSBackend(storagePath)
+	fsBackend.keepTempFiles = true
+	backend = fsBackend
+	err := loadBucketsData()
+	if err != nil {
+		return err
+	}
+
+	report := &fsckReport{}
+	var transactions []journalTransaction
+	journal, transactions, err = openJournal(filepath.Join(storagePath, journalFileName))
+	if err != nil {
+		return err
+	}
+	if repair {
+		err = recoverJournal(transactions)
+		if err != nil {
+			return err
+		}
+	}
+	for _, transaction := range transactions {
+		report.add(journalFileName, fmt.Sprint(transaction.id), problemUnfinishedJournal, repair)
+	}
+
+	err = fsBackend.checkBuckets(report, repair)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"", iamDirName} {
+		err = fsBackend.checkTempFiles(report, dir, repair)
+		if err != nil {
+			return err
+		}
+	}
+	for _, bucketName := range store.names() {
+		err = fsBackend.checkObjects(report, store.buckets[bucketName], repair, rehash)
+		if err != nil {
+			return err
+		}
+	}
+
+	unrepaired := 0
+	for _, problem := range report.problems {
+		status := ""
+		if problem.repaired {
+			status = " (repaired)"
+		} else {
+			unrepaired++
+		}
+		location := problem.bucketName
+		if location != "" && problem.name != "" {
+			location += ": "
+		}
+		location += problem.name
+		fmt.Printf("%s: %s%s\n", location, problem.problem, status)
+	}
+	fmt.Printf("checked %d buckets and %d objects, %d problems found, %d repaired\n",
+		report.buckets, report.objects, len(report.problems), len(report.problems)-unrepaired)
+	if unrepaired > 0 {
+		return ErrInconsistentStorage
+	}
+	return nil
+}
+
+// Compares bucket directories with buckets.csv. Directories with a valid bucket name are adopted,
+// the rest are left alone, buckets without a directory are dropped
+func (b *fsBackend) checkBuckets(report *fsckReport, repair bool) error {
+	entries, err := os.ReadDir(b.root)
+	if err != nil {
+		return fmt.Errorf("error while reading storage directory: %w", err)
+	}
+	changed := false
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if _, exists := store.buckets[entry.Name()]; exists {
+			continue
+		}
+		adopted := false
+		if repair && validateBucketName(entry.Name()) == nil {
+			objects, err := b.loadObjects(entry.Name())
+			if err != nil {
+				return err
+			}
+			versions, err := b.loadVersions(entry.Name())
+			if err != nil {
+				return err
+			}
+			status := "inactive"
+			if objects.len() > 0 {
+				status = "active"
+			}
+			now := time.Now().Format(time.RFC822)
+			store.buckets[entry.Name()] = &bucketData{
+				Name:             entry.Name(),
+				CreatedTime:      now,
+				LastModifiedTime: now,
+				Status:           status,
+				objects:          objects,
+				versions:         versions,
+			}
+			adopted, changed = true, true
+		}
+		report.add(entry.Name(), "", problemUnlistedBucket, adopted)
+	}
+
+	for _, bucketName := range store.sortedNames() {
+		if _, err := os.Stat(b.bucketPath(bucketName)); !os.IsNotExist(err) {
+			continue
+		}
+		if repair {
+			delete(store.buckets, bucketName)
+			changed = true
+		}
+		report.add(bucketName, "", problemMissingBucket, repair)
+	}
+
+	if changed {
+		err = saveBucketsData()
+		if err != nil {
+			return fmt.Errorf("error while saving buckets metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// Checks the contents of the bucket's objects and looks for contents without records
+func (b *fsBackend) checkObjects(report *fsckReport, bucket *bucketData, repair, rehash bool) error {
+	// Missing directory is reported by checkBuckets
+	if _, err := os.Stat(b.bucketPath(bucket.Name)); os.IsNotExist(err) {
+		return nil
+	}
+	report.buckets++
+	changes := []objectChange{}
+	expectedFiles := map[string]bool{}
+	var checkErr error
+	bucket.objects.ascend("", func(object bucketObject) bool {
+		report.objects++
+		expectedFiles[encodeObjectKey(object.objectKey)] = true
+		problem, checksum, err := checkObjectContent(bucket.Name, object, rehash)
+		if err != nil {
+			checkErr = err
+			return false
+		}
+		repaired := false
+		switch problem {
+		case "":
+			return true
+		case problemMissingContent:
+			if repair {
+				changes = append(changes, removedObjectChange(object.objectKey))
+				repaired = true
+			}
+		case problemMissingChecksum:
+			if repair {
+				object.etag = checksum
+				changes = append(changes, objectChange{object: object})
+				repaired = true
+			}
+		}
+		report.add(bucket.Name, object.objectKey, problem, repaired)
+		return true
+	})
+	if checkErr != nil {
+		return checkErr
+	}
+
+	entries, err := os.ReadDir(b.bucketPath(bucket.Name))
+	if err != nil {
+		return fmt.Errorf("error while reading <%s> bucket directory: %w", bucket.Name, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (strings.HasPrefix(name, ".") && !isTempFile(name)) || name == objectsMetadataFileName || name == objectsLogFileName || expectedFiles[name] {
+			continue
+		}
+		// Key of the content is unknown, so there is nothing to rebuild its record from
+		removed := false
+		if repair {
+			err = os.Remove(filepath.Join(b.bucketPath(bucket.Name), name))
+			if err != nil {
+				return fmt.Errorf("error while removing <%s> file in <%s> bucket: %w", name, bucket.Name, err)
+			}
+			removed = true
+		}
+		report.add(bucket.Name, name, problemOrphanedContent, removed)
+	}
+
+	uploads, err := os.ReadDir(filepath.Join(b.bucketPath(bucket.Name), multipartDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error while reading <%s> bucket multipart directory: %w", bucket.Name, err)
+	}
+	for _, upload := range uploads {
+		if upload.IsDir() {
+			err = b.checkTempFiles(report, filepath.Join(bucket.Name, multipartDirName, upload.Name()), repair)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, change := range changes {
+		if change.removed {
+			bucket.objects.remove(change.object.objectKey)
+		} else {
+			bucket.objects.set(change.object)
+		}
+	}
+	err = saveObjectsData(bucket, changes...)
+	if err != nil {
+		return fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucket.Name, err)
+	}
+	return nil
+}
+
+// Reports the temporary files left by interrupted writes in the directory of the storage
+// and removes them with repair, the content they held never replaced anything
+func (b *fsBackend) checkTempFiles(report *fsckReport, dir string, repair bool) error {
+	entries, err := os.ReadDir(filepath.Join(b.root, dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error while reading <%s> directory: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isTempFile(entry.Name()) {
+			continue
+		}
+		if repair {
+			err = os.Remove(filepath.Join(b.root, dir, entry.Name()))
+			if err != nil {
+				return fmt.Errorf("error while removing temporary file <%s> in <%s>: %w", entry.Name(), dir, err)
+			}
+		}
+		report.add(dir, entry.Name(), problemOrphanedContent, repair)
+	}
+	return nil
+}
+
+// Re-hashes the contents of all objects every scrubInterval and logs the problems, runs forever.
+// Records are copied under the bucket lock and checked without it, a problem is only logged
+// if the record did not change meanwhile
+func scrubObjects() {
+	ticker := time.NewTicker(scrubInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		checked, found := 0, 0
+		for _, bucketName := range store.names() {
+			bucket, err := store.rlockBucket(bucketName)
+			if err != nil {
+				continue
+			}
+			objects := make([]bucketObject, 0, bucket.objects.len())
+			bucket.objects.ascend("", func(object bucketObject) bool {
+				objects = append(objects, object)
+				return true
+			})
+			bucket.mu.RUnlock()
+
+			for _, object := range objects {
+				checked++
+				problem, _, err := checkObjectContent(bucketName, object, true)
+				if err != nil {
+					log.Printf("scrub of <%s> object in <%s> bucket failed: %s", object.objectKey, bucketName, err)
+					continue
+				}
+				if problem == "" || !objectUnchanged(bucketName, object) {
+					continue
+				}
+				found++
+				log.Printf("scrub: <%s> object in <%s> bucket: %s", object.objectKey, bucketName, problem)
+			}
+		}
+		log.Printf("scrub checked %d objects, %d problems found", checked, found)
+	}
+}
+
+// Reports whether the record of the object is still the same
+func objectUnchanged(bucketName string, object bucketObject) bool {
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		return false
+	}
+	defer bucket.mu.RUnlock()
+	current, err := findObject(bucket, object.objectKey)
+	return err == nil && current.etag == object.etag && current.lastModified == object.lastModified
+}
@@ -0,0 +1,266 @@
+package web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Errors
+var ErrUnknownLogOperation = errors.New("unknown operation in the metadata log")
+
+// Log of the changes made to the bucket's objects metadata after objects.csv was written
+const objectsLogFileName = "objects.log"
+
+// Operations of the objects.log records:
+//
+//	put,<objects.csv record>
+//	remove,<key>
+const (
+	logPutOperation    = "put"
+	logRemoveOperation = "remove"
+)
+
+// objects.log is compacted into objects.csv once it has more records than the bucket has objects,
+// so the cost of rewriting objects.csv is spread over at least as many changes
+const minCompactionLogRecords = 1000
+
+// Reads the records of an append-only log and applies them in order, reports whether a torn record was dropped.
+// Records end with a newline, so only the last one may be torn by a crash: an unterminated last line or
+// a last record which is not valid csv is dropped, any other bad record is an error
+func readLogRecords(logData []byte, apply func(record []string) error) (bool, error) {
+	complete := logData[:bytes.LastIndexByte(logData, '\n')+1]
+	torn := len(complete) < len(logData)
+
+	logCsvReader := csv.NewReader(bytes.NewReader(complete))
+	logCsvReader.FieldsPerRecord = -1
+	for {
+		logRecord, err := logCsvReader.Read()
+		if err == io.EOF {
+			return torn, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if _, nextErr := logCsvReader.Read(); nextErr == io.EOF {
+				return true, nil
+			}
+			return false, fmt.Errorf("error while parsing the record at line %d: %w", parseErr.StartLine, err)
+		} else if err != nil {
+			return false, err
+		}
+		err = apply(logRecord)
+		if err != nil {
+			line, _ := logCsvReader.FieldPos(0)
+			return false, fmt.Errorf("error while applying the record at line %d: %w", line, err)
+		}
+	}
+}
+
+// Encodes the metadata record of the object as an objects.csv record
+func encodeObjectRecord(object bucketObject) []string {
+	return []string{object.objectKey, strconv.Itoa(object.contentLength), object.contentType, object.lastModified, object.etag, encodeObjectMetadata(object.metadata), object.acl, object.versionID, strconv.FormatBool(object.deleteMarker), encodeObjectTags(object.tags)}
+}
+
+// Decodes an objects.csv record, older records have less fields
+func decodeObjectRecord(record []string) (bucketObject, error) {
+	if len(record) < 4 {
+		return bucketObject{}, ErrInvalidNumberOfFields
+	}
+	length, err := strconv.Atoi(record[1])
+	if err != nil {
+		return bucketObject{}, fmt.Errorf("error while converting <%s> object length to integer: %w", record[0], err)
+	}
+	object := bucketObject{
+		objectKey:     record[0],
+		contentLength: length,
+		contentType:   record[2],
+		lastModified:  record[3],
+	}
+	if len(record) > 4 {
+		object.etag = record[4]
+	}
+	if len(record) > 5 {
+		object.metadata, err = decodeObjectMetadata(record[5])
+		if err != nil {
+			return bucketObject{}, fmt.Errorf("error while decoding <%s> object metadata: %w", record[0], err)
+		}
+	}
+	if len(record) > 6 {
+		object.acl = record[6]
+	}
+	if len(record) > 8 {
+		object.versionID = record[7]
+		object.deleteMarker = record[8] == "true"
+	}
+	if len(record) > 9 {
+		object.tags, err = decodeObjectTags(record[9])
+		if err != nil {
+			return bucketObject{}, fmt.Errorf("error while decoding <%s> object tags: %w", record[0], err)
+		}
+	}
+	return object, nil
+}
+
+// Reads objects.csv and replays objects.log over it.
+// A replayed log is compacted right away, which also drops a record torn by a crash.
+// A corrupted log is an error and is left in place
+func (b *fsBackend) loadObjects(bucketName string) (*objectIndex, error) {
+	objects := []bucketObject{}
+	objectMetaDataPath := filepath.Join(b.bucketPath(bucketName), objectsMetadataFileName)
+	objectMetaDataFile, err := os.OpenFile(objectMetaDataPath, os.O_RDONLY, 0o644)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error while reading <%s> object metadata file: %w", bucketName, err)
+		}
+		_, err = os.OpenFile(objectMetaDataPath, os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading the <%s> bucket and creating its metadata file: %w", bucketName, err)
+		}
+	} else {
+		defer objectMetaDataFile.Close()
+		bucketCsvReader := csv.NewReader(objectMetaDataFile)
+		bucketCsvReader.FieldsPerRecord = -1
+		for {
+			bucketRecord, err := bucketCsvReader.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, fmt.Errorf("error while reading <%s> bucket's metadata file: %w", bucketName, err)
+			}
+			object, err := decodeObjectRecord(bucketRecord)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, object)
+		}
+	}
+	index := newObjectIndex(objects)
+
+	replayed, err := b.replayObjectsLog(bucketName, index)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		err = b.compactObjects(bucketName, index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// Applies the records of objects.log to the index, reports whether the log exists
+func (b *fsBackend) replayObjectsLog(bucketName string, index *objectIndex) (bool, error) {
+	logData, err := os.ReadFile(filepath.Join(b.bucketPath(bucketName), objectsLogFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error while reading <%s> bucket's metadata log: %w", bucketName, err)
+	}
+	torn, err := readLogRecords(logData, func(logRecord []string) error {
+		if len(logRecord) < 2 {
+			return ErrInvalidNumberOfFields
+		}
+		switch logRecord[0] {
+		case logPutOperation:
+			object, err := decodeObjectRecord(logRecord[1:])
+			if err != nil {
+				return err
+			}
+			index.set(object)
+		case logRemoveOperation:
+			index.remove(logRecord[1])
+		default:
+			return ErrUnknownLogOperation
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error while replaying <%s> bucket's metadata log: %w", bucketName, err)
+	}
+	if torn {
+		log.Printf("torn last record of <%s> bucket's metadata log dropped", bucketName)
+	}
+	return true, nil
+}
+
+// Appends the changes to objects.log, or rewrites objects.csv from the index once the log grew large
+func (b *fsBackend) SaveObjects(bucketName string, objects *objectIndex, changes []objectChange) error {
+	b.mu.Lock()
+	logRecords := b.logRecords[bucketName] + len(changes)
+	b.mu.Unlock()
+	if logRecords > max(minCompactionLogRecords, objects.len()) {
+		return b.compactObjects(bucketName, objects)
+	}
+
+	logPath := filepath.Join(b.bucketPath(bucketName), objectsLogFileName)
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error while opening <%s> bucket's metadata log: %w", bucketName, err)
+	}
+	defer logFile.Close()
+
+	csvWriter := csv.NewWriter(logFile)
+	for _, change := range changes {
+		if change.removed {
+			csvWriter.Write([]string{logRemoveOperation, change.object.objectKey})
+		} else {
+			csvWriter.Write(append([]string{logPutOperation}, encodeObjectRecord(change.object)...))
+		}
+	}
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	if err == nil {
+		err = logFile.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("error while writing <%s> bucket's metadata log: %w", bucketName, err)
+	}
+
+	b.mu.Lock()
+	b.logRecords[bucketName] = logRecords
+	b.mu.Unlock()
+	return nil
+}
+
+// Rewrites objects.csv from the index in key order and drops objects.log.
+// A crash in between replays the log over the new objects.csv, which gives the same records
+func (b *fsBackend) compactObjects(bucketName string, objects *objectIndex) error {
+	bucketMetadataPath := filepath.Join(b.bucketPath(bucketName), objectsMetadataFileName)
+	err := writeFileAtomic(bucketMetadataPath, func(w io.Writer) error {
+		csvWriter := csv.NewWriter(w)
+		objects.ascend("", func(object bucketObject) bool {
+			csvWriter.Write(encodeObjectRecord(object))
+			return true
+		})
+		csvWriter.Flush()
+		err := csvWriter.Error()
+		if err != nil {
+			return fmt.Errorf("error while writing metadata to <%s> file: %w", bucketMetadataPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return b.removeObjectsLog(bucketName)
+}
+
+func (b *fsBackend) removeObjectsLog(bucketName string) error {
+	err := os.Remove(filepath.Join(b.bucketPath(bucketName), objectsLogFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error while removing <%s> bucket's metadata log: %w", bucketName, err)
+	}
+	b.mu.Lock()
+	delete(b.logRecords, bucketName)
+	b.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,233 @@
+package web
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Log of the bucket's noncurrent versions, it starts with a dot so it never clashes with an object file:
+//
+//	put,<objects.csv record>    the record becomes the newest noncurrent version of its key,
+//	                            or replaces the record of the listed version
+//	remove,<key>,<version id>   the noncurrent version is dropped
+const versionsLogFileName = ".versions.log"
+
+// Directory of the noncurrent versions' contents, <encoded key>/<version id> inside it
+const versionsDirName = ".versions"
+
+// File name of the null version's content
+const nullVersionFileName = "null"
+
+func (b *fsBackend) versionPath(bucketName, objectKey, versionID string) string {
+	if versionID == "" {
+		versionID = nullVersionFileName
+	}
+	return filepath.Join(b.root, bucketName, versionsDirName, encodeObjectKey(objectKey), versionID)
+}
+
+// Reads the noncurrent versions of the bucket from its log, newest first for every key.
+// A log with a record torn by a crash or with many dropped versions is compacted right away
+func (b *fsBackend) loadVersions(bucketName string) (map[string][]bucketObject, error) {
+	versions := map[string][]bucketObject{}
+	logData, err := os.ReadFile(filepath.Join(b.bucketPath(bucketName), versionsLogFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, nil
+		}
+		return nil, fmt.Errorf("error while reading <%s> bucket's versions log: %w", bucketName, err)
+	}
+	records := 0
+	torn, err := readLogRecords(logData, func(logRecord []string) error {
+		if len(logRecord) < 2 {
+			return ErrInvalidNumberOfFields
+		}
+		records++
+		switch logRecord[0] {
+		case logPutOperation:
+			version, err := decodeObjectRecord(logRecord[1:])
+			if err != nil {
+				return err
+			}
+			list := versions[version.objectKey]
+			if idx, listed := findVersion(list, version.versionID); listed {
+				list[idx] = version
+			} else {
+				versions[version.objectKey] = append([]bucketObject{version}, list...)
+			}
+		case logRemoveOperation:
+			if len(logRecord) < 3 {
+				return ErrInvalidNumberOfFields
+			}
+			removeVersionFromList(versions, logRecord[1], logRecord[2])
+		default:
+			return ErrUnknownLogOperation
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while replaying <%s> bucket's versions log: %w", bucketName, err)
+	}
+
+	b.mu.Lock()
+	b.versionLogRecords[bucketName] = records
+	b.mu.Unlock()
+	if torn || records > max(minCompactionLogRecords, 2*countVersions(versions)) {
+		err = b.compactVersions(bucketName, versions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// Appends the changes to the versions log, or rewrites the log from the lists once it grew large
+func (b *fsBackend) SaveVersions(bucketName string, versions map[string][]bucketObject, changes []objectChange) error {
+	b.mu.Lock()
+	logRecords := b.versionLogRecords[bucketName] + len(changes)
+	b.mu.Unlock()
+	if logRecords > max(minCompactionLogRecords, 2*countVersions(versions)) {
+		return b.compactVersions(bucketName, versions)
+	}
+
+	logPath := filepath.Join(b.bucketPath(bucketName), versionsLogFileName)
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error while opening <%s> bucket's versions log: %w", bucketName, err)
+	}
+	defer logFile.Close()
+
+	csvWriter := csv.NewWriter(logFile)
+	for _, change := range changes {
+		if change.removed {
+			csvWriter.Write([]string{logRemoveOperation, change.object.objectKey, change.object.versionID})
+		} else {
+			csvWriter.Write(append([]string{logPutOperation}, encodeObjectRecord(change.object)...))
+		}
+	}
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	if err == nil {
+		err = logFile.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("error while writing <%s> bucket's versions log: %w", bucketName, err)
+	}
+
+	b.mu.Lock()
+	b.versionLogRecords[bucketName] = logRecords
+	b.mu.Unlock()
+	return nil
+}
+
+// Rewrites the versions log with a put record of every noncurrent version, oldest first
+func (b *fsBackend) compactVersions(bucketName string, versions map[string][]bucketObject) error {
+	logPath := filepath.Join(b.bucketPath(bucketName), versionsLogFileName)
+	count := countVersions(versions)
+	if count == 0 {
+		return b.removeVersionsLog(bucketName)
+	}
+
+	objectKeys := make([]string, 0, len(versions))
+	for objectKey := range versions {
+		objectKeys = append(objectKeys, objectKey)
+	}
+	sort.Strings(objectKeys)
+	err := writeFileAtomic(logPath, func(w io.Writer) error {
+		csvWriter := csv.NewWriter(w)
+		for _, objectKey := range objectKeys {
+			list := versions[objectKey]
+			for idx := len(list) - 1; idx >= 0; idx-- {
+				csvWriter.Write(append([]string{logPutOperation}, encodeObjectRecord(list[idx])...))
+			}
+		}
+		csvWriter.Flush()
+		err := csvWriter.Error()
+		if err != nil {
+			return fmt.Errorf("error while writing <%s> bucket's versions log: %w", bucketName, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.versionLogRecords[bucketName] = count
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *fsBackend) removeVersionsLog(bucketName string) error {
+	err := os.Remove(filepath.Join(b.bucketPath(bucketName), versionsLogFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error while removing <%s> bucket's versions log: %w", bucketName, err)
+	}
+	b.mu.Lock()
+	delete(b.versionLogRecords, bucketName)
+	b.mu.Unlock()
+	return nil
+}
+
+// The current content gets a second name, so replacing or removing the current one keeps the version
+func (b *fsBackend) ArchiveObject(bucketName, objectKey, versionID string) error {
+	versionPath := b.versionPath(bucketName, objectKey, versionID)
+	err := os.MkdirAll(filepath.Dir(versionPath), 0o755)
+	if err != nil {
+		return fmt.Errorf("error while creating versions directory of <%s> object: %w", objectKey, err)
+	}
+	err = os.Link(b.objectPath(bucketName, objectKey), versionPath)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	return syncDir(filepath.Dir(versionPath))
+}
+
+func (b *fsBackend) RestoreObject(bucketName, objectKey, versionID string) error {
+	versionPath := b.versionPath(bucketName, objectKey, versionID)
+	err := os.Rename(versionPath, b.objectPath(bucketName, objectKey))
+	if err != nil {
+		// Version restored before a crash is already in place
+		if _, statErr := os.Stat(b.objectPath(bucketName, objectKey)); os.IsNotExist(err) && statErr == nil {
+			return nil
+		}
+		return err
+	}
+	// Directory of the key is left once its last version is gone
+	os.Remove(filepath.Dir(versionPath))
+	return syncDir(b.bucketPath(bucketName))
+}
+
+func (b *fsBackend) OpenVersion(bucketName, objectKey, versionID string) (ObjectReader, error) {
+	return openFileReader(b.versionPath(bucketName, objectKey, versionID))
+}
+
+func (b *fsBackend) RemoveVersion(bucketName, objectKey, versionID string) error {
+	versionPath := b.versionPath(bucketName, objectKey, versionID)
+	err := os.Remove(versionPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(filepath.Dir(versionPath))
+	return nil
+}
+
+// Reports whether any noncurrent version content is left in the bucket
+func (b *fsBackend) hasVersionContents(bucketName string) (bool, error) {
+	keyDirs, err := os.ReadDir(filepath.Join(b.bucketPath(bucketName), versionsDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, keyDir := range keyDirs {
+		entries, err := os.ReadDir(filepath.Join(b.bucketPath(bucketName), versionsDirName, keyDir.Name()))
+		if err != nil || len(entries) > 0 {
+			return true, err
+		}
+	}
+	return false, nil
+}
@@ -2,6 +2,8 @@ package web
 
 import (
 	"encoding/xml"
+	"fmt"
+	"html"
 	"log"
 	"net/http"
 )
@@ -45,6 +47,30 @@ func respondError(w http.ResponseWriter, r *http.Request, statusCode int, err er
 	}
 }
 
+// Page of the errors on the website endpoint
+const htmlErrorPage = `<html>
+<head><title>%[1]s</title></head>
+<body>
+<h1>%[1]s</h1>
+<ul>
+<li>Code: %[2]s</li>
+<li>Message: %[3]s</li>
+</ul>
+<hr/>
+</body>
+</html>
+`
+
+// Website endpoint responds the errors with an HTML page for the browsers instead of the XML document
+func respondErrorHTML(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+	message, code := mapErrorToMessageAndCode(err)
+	status := fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	fmt.Fprintf(w, htmlErrorPage, status, html.EscapeString(code), html.EscapeString(message))
+	log.Printf("error while executing website URL: %s; with error: %s", r.URL.String(), err)
+}
+
 // Error messages
 const (
 	ResourceDoesNotExist        = "The resource you requested does not exist"
@@ -68,7 +94,62 @@ const (
 	NoSuchKey                = "NoSuchKey"
 	MethodNotAllowed         = "MethodNotAllowed"
 	BadRequest               = "BadReqest"
-	ExistingKey              = "ExistingKey"
+	InvalidRange             = "InvalidRange"
+	PreconditionFailed       = "PreconditionFailed"
+	NoSuchUpload             = "NoSuchUpload"
+	InvalidPart              = "InvalidPart"
+	InvalidPartOrder         = "InvalidPartOrder"
+	EntityTooSmall           = "EntityTooSmall"
+	MalformedXML             = "MalformedXML"
+	InvalidRequest           = "InvalidRequest"
+	MetadataTooLarge         = "MetadataTooLarge"
+	BadDigest                = "BadDigest"
+	NoSuchVersion            = "NoSuchVersion"
+)
+
+// Tagging error codes
+const (
+	InvalidTag = "InvalidTag"
+)
+
+// CORS error codes
+const (
+	NoSuchCORSConfiguration = "NoSuchCORSConfiguration"
+	AccessForbidden         = "AccessForbidden"
+)
+
+// Website error codes
+const (
+	NoSuchWebsiteConfiguration = "NoSuchWebsiteConfiguration"
+)
+
+// Lifecycle error codes
+const (
+	NoSuchLifecycleConfiguration = "NoSuchLifecycleConfiguration"
+)
+
+// Versioning error codes
+const (
+	IllegalVersioningConfiguration = "IllegalVersioningConfigurationException"
+)
+
+// Authentication error codes
+const (
+	AccessDenied                      = "AccessDenied"
+	SignatureDoesNotMatch             = "SignatureDoesNotMatch"
+	InvalidAccessKeyId                = "InvalidAccessKeyId"
+	RequestTimeTooSkewed              = "RequestTimeTooSkewed"
+	AuthorizationHeaderMalformed      = "AuthorizationHeaderMalformed"
+	AuthorizationQueryParametersError = "AuthorizationQueryParametersError"
+	XAmzContentSHA256Mismatch         = "XAmzContentSHA256Mismatch"
+)
+
+// Access control error codes
+const (
+	NoSuchEntity            = "NoSuchEntity"
+	EntityAlreadyExists     = "EntityAlreadyExists"
+	MalformedPolicyDocument = "MalformedPolicyDocument"
+	NoSuchBucketPolicy      = "NoSuchBucketPolicy"
 )
 
 // Map certain error to general message message, code is more certain
@@ -82,25 +163,122 @@ func mapErrorToMessageAndCode(err error) (message string, code string) {
 	case ErrObjectNotExists:
 		message, code = ErrNoSuchKey, NoSuchKey
 	case ErrObjectAlreadyExists:
-		message, code = ErrObjectAlreadyExists.Error(), ExistingKey
+		message, code = ErrObjectAlreadyExists.Error(), PreconditionFailed
+	case ErrPreconditionFailed:
+		message, code = ErrPreconditionFailed.Error(), PreconditionFailed
 	case ErrConsecutiveHyphenDot,
 		ErrInvalidCharacters,
-		ErrManySegments,
 		ErrStartWithHyphen,
 		ErrTooLongName,
 		ErrTooShortName,
 		ErrValidIPAddress,
-		ErrEndWithHyphenDot:
+		ErrEndWithHyphenDot,
+		ErrEmptyObjectKey,
+		ErrTooLongObjectKey,
+		ErrInvalidObjectKey,
+		ErrProhibitedObjectName,
+		ErrProhibitedBucketName,
+		ErrInvalidListType,
+		ErrInvalidMaxKeys,
+		ErrInvalidContinuationToken,
+		ErrInvalidPartNumber,
+		ErrInvalidMaxParts,
+		ErrInvalidCopySource,
+		ErrInvalidMetadataDirective,
+		ErrInvalidPresignMethod,
+		ErrInvalidPresignExpires,
+		ErrInvalidUserName,
+		ErrInvalidCannedACL,
+		ErrInvalidVersionID,
+		ErrInvalidLifecycleDays,
+		ErrInvalidLifecycleDate,
+		ErrInvalidLifecycleRuleID,
+		ErrNoLifecycleAction,
+		ErrLifecycleUploadsTagFilter:
 
 		message, code = err.Error(), InvalidArgument
 	case ErrTooBigObject:
 		message, code = ErrEntityTooLarge, MaxMessageLengthExceeded
-	case ErrUndefinedContentLength:
+	case ErrIncompleteBody:
 		message, code = RequestIncompleteBody, IncompleteBody
+	case ErrInvalidChunkedEncoding,
+		ErrInvalidDecodedLength,
+		ErrUnexpectedContentLength:
+
+		message, code = err.Error(), InvalidRequest
+	case ErrInvalidRange:
+		message, code = ErrInvalidRange.Error(), InvalidRange
+	case ErrNoSuchUpload:
+		message, code = ErrNoSuchUpload.Error(), NoSuchUpload
+	case ErrInvalidPart:
+		message, code = ErrInvalidPart.Error(), InvalidPart
+	case ErrInvalidPartOrder:
+		message, code = ErrInvalidPartOrder.Error(), InvalidPartOrder
+	case ErrEntityTooSmall:
+		message, code = ErrEntityTooSmall.Error(), EntityTooSmall
+	case ErrMalformedXML:
+		message, code = ErrMalformedXML.Error(), MalformedXML
+	case ErrCopyToItself, ErrAuthenticationDisabled:
+		message, code = err.Error(), InvalidRequest
+	case ErrBadDigest:
+		message, code = ErrBadDigest.Error(), BadDigest
+	case ErrMetadataTooLarge:
+		message, code = ErrMetadataTooLarge.Error(), MetadataTooLarge
 	case ErrNoSuchResource:
 		message, code = ErrNoSuchResource.Error(), NoSuchResource
 	case ErrMethodNotAllowed:
 		message, code = ErrMethodNotAllowed.Error(), MethodNotAllowed
+	case ErrAccessDenied, ErrRequestExpired, ErrPresignedSignatureMismatch:
+		message, code = err.Error(), AccessDenied
+	case ErrSignatureDoesNotMatch:
+		message, code = ErrSignatureDoesNotMatch.Error(), SignatureDoesNotMatch
+	case ErrInvalidAccessKeyID:
+		message, code = ErrInvalidAccessKeyID.Error(), InvalidAccessKeyId
+	case ErrRequestTimeTooSkewed:
+		message, code = ErrRequestTimeTooSkewed.Error(), RequestTimeTooSkewed
+	case ErrAuthorizationHeaderMalformed:
+		message, code = ErrAuthorizationHeaderMalformed.Error(), AuthorizationHeaderMalformed
+	case ErrAuthorizationQueryMalformed:
+		message, code = ErrAuthorizationQueryMalformed.Error(), AuthorizationQueryParametersError
+	case ErrContentSHA256Mismatch:
+		message, code = ErrContentSHA256Mismatch.Error(), XAmzContentSHA256Mismatch
+	case ErrNoSuchBucketPolicy:
+		message, code = ErrNoSuchBucketPolicy.Error(), NoSuchBucketPolicy
+	case ErrNoSuchUser, ErrNoSuchAccessKey, ErrNoSuchPolicy:
+		message, code = err.Error(), NoSuchEntity
+	case ErrUserAlreadyExists:
+		message, code = ErrUserAlreadyExists.Error(), EntityAlreadyExists
+	case ErrMalformedPolicy:
+		message, code = ErrMalformedPolicy.Error(), MalformedPolicyDocument
+	case ErrNoSuchVersion:
+		message, code = ErrNoSuchVersion.Error(), NoSuchVersion
+	case ErrVersionIsDeleteMarker:
+		message, code = ErrVersionIsDeleteMarker.Error(), MethodNotAllowed
+	case ErrTooManyTags, ErrInvalidTagKey, ErrInvalidTagValue, ErrDuplicateTagKey, ErrInvalidTaggingHeader:
+		message, code = err.Error(), InvalidTag
+	case ErrInvalidTagDirective:
+		message, code = err.Error(), InvalidArgument
+	case ErrNoSuchCORSConfiguration:
+		message, code = ErrNoSuchCORSConfiguration.Error(), NoSuchCORSConfiguration
+	case ErrCORSNotAllowed, ErrMissingCORSHeaders:
+		message, code = err.Error(), AccessForbidden
+	case ErrInvalidCORSMethod, ErrInvalidCORSWildcard:
+		message, code = err.Error(), InvalidRequest
+	case ErrInvalidCORSMaxAge:
+		message, code = err.Error(), InvalidArgument
+	case ErrNoSuchWebsiteConfiguration:
+		message, code = ErrNoSuchWebsiteConfiguration.Error(), NoSuchWebsiteConfiguration
+	case ErrInvalidIndexDocument,
+		ErrInvalidRedirectProtocol,
+		ErrInvalidRedirectCode,
+		ErrInvalidRedirectKey,
+		ErrInvalidErrorCodeCondition:
+
+		message, code = err.Error(), InvalidArgument
+	case ErrNoSuchLifecycleConfiguration:
+		message, code = ErrNoSuchLifecycleConfiguration.Error(), NoSuchLifecycleConfiguration
+	case ErrInvalidVersioningStatus:
+		message, code = ErrInvalidVersioningStatus.Error(), IllegalVersioningConfiguration
 	default:
 		message, code = err.Error(), BadRequest
 	}
@@ -0,0 +1,654 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrNoSuchUser        = errors.New("the user does not exist")
+	ErrUserAlreadyExists = errors.New("the user already exists")
+	ErrInvalidUserName   = errors.New("user name must be 1-64 characters of letters, digits and +=,.@_-")
+	ErrNoSuchAccessKey   = errors.New("the access key does not exist")
+	ErrNoSuchPolicy      = errors.New("the user has no policy")
+)
+
+// Directory of the user registry inside the storage, bucket names never start with a dot
+const iamDirName = ".iam"
+
+// Pattern of the IAM user names
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9+=,.@_-]{1,64}$`)
+
+type iamUser struct {
+	name    string
+	created string
+	// Raw JSON of the policy, empty when the user has none and may do nothing
+	policyJSON string
+	policy     *policyDocument
+}
+
+type accessKey struct {
+	accessKeyID string
+	secretKey   string
+	userName    string
+	created     string
+}
+
+// iamRegistry keeps the users and their access keys:
+//
+//	.iam/users.csv    name, created, policy JSON
+//	.iam/keys.csv     access key ID, secret access key, user name, created
+//
+// Keys of the --credentials file belong to the root, which is allowed everything and manages the users
+type iamRegistry struct {
+	mu    sync.RWMutex
+	dir   string
+	users map[string]*iamUser
+	keys  map[string]*accessKey
+}
+
+// Global registry of the users, set by Init
+var registry *iamRegistry
+
+func newIAMRegistry(dir string) *iamRegistry {
+	return &iamRegistry{dir: dir, users: map[string]*iamUser{}, keys: map[string]*accessKey{}}
+}
+
+// Loads the registry from the directory, a missing directory is an empty registry
+func loadIAMRegistry(dir string) (*iamRegistry, error) {
+	r := newIAMRegistry(dir)
+	userRecords, err := readCSVFile(filepath.Join(dir, "users.csv"))
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range userRecords {
+		if len(record) != 3 {
+			return nil, ErrInvalidNumberOfFields
+		}
+		user := &iamUser{name: record[0], created: record[1], policyJSON: record[2]}
+		if user.policyJSON != "" {
+			user.policy, err = parseUserPolicy([]byte(user.policyJSON))
+			if err != nil {
+				return nil, fmt.Errorf("error while parsing policy of <%s> user: %w", user.name, err)
+			}
+		}
+		r.users[user.name] = user
+	}
+
+	keyRecords, err := readCSVFile(filepath.Join(dir, "keys.csv"))
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range keyRecords {
+		if len(record) != 4 {
+			return nil, ErrInvalidNumberOfFields
+		}
+		r.keys[record[0]] = &accessKey{accessKeyID: record[0], secretKey: record[1], userName: record[2], created: record[3]}
+	}
+	log.Printf("loaded %d users and %d access keys", len(r.users), len(r.keys))
+	return r, nil
+}
+
+// Reads all records of the CSV file, a missing file has no records
+func readCSVFile(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error while opening <%s> file: %w", path, err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading <%s> file: %w", path, err)
+	}
+	return records, nil
+}
+
+// Rewrites the registry files, the caller must hold the registry lock for writing
+func (r *iamRegistry) save() error {
+	err := os.MkdirAll(r.dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("error while creating the users directory: %w", err)
+	}
+
+	userNames := make([]string, 0, len(r.users))
+	for userName := range r.users {
+		userNames = append(userNames, userName)
+	}
+	sort.Strings(userNames)
+	err = writeFileAtomic(filepath.Join(r.dir, "users.csv"), func(w io.Writer) error {
+		csvWriter := csv.NewWriter(w)
+		for _, userName := range userNames {
+			user := r.users[userName]
+			csvWriter.Write([]string{user.name, user.created, user.policyJSON})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	})
+	if err != nil {
+		return fmt.Errorf("error while saving users: %w", err)
+	}
+
+	keyIDs := make([]string, 0, len(r.keys))
+	for keyID := range r.keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	err = writeFileAtomic(filepath.Join(r.dir, "keys.csv"), func(w io.Writer) error {
+		csvWriter := csv.NewWriter(w)
+		for _, keyID := range keyIDs {
+			key := r.keys[keyID]
+			csvWriter.Write([]string{key.accessKeyID, key.secretKey, key.userName, key.created})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	})
+	if err != nil {
+		return fmt.Errorf("error while saving access keys: %w", err)
+	}
+	return nil
+}
+
+// Returns the secret key and the user of the access key, the user is empty for root keys
+func lookupAccessKey(accessKeyID string) (string, string, bool) {
+	if secretKey, exists := credentials[accessKeyID]; exists {
+		return secretKey, "", true
+	}
+	if registry == nil {
+		return "", "", false
+	}
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	key, exists := registry.keys[accessKeyID]
+	if !exists {
+		return "", "", false
+	}
+	return key.secretKey, key.userName, true
+}
+
+// Resource ARN of the bucket, or of the object when the key is given
+func resourceARN(bucketName, objectKey string) string {
+	if objectKey == "" {
+		return "arn:aws:s3:::" + bucketName
+	}
+	return "arn:aws:s3:::" + bucketName + "/" + objectKey
+}
+
+// Checks that the caller of the request may perform the action on the bucket or on its object.
+// Everything is allowed when authentication is off and for the root keys.
+// Otherwise an explicit deny of the user or the bucket policy wins,
+// then the action must be allowed by either of the policies or by the canned ACL.
+// Policies and ACLs cannot be set without authentication.
+// Version is the requested version of the object, empty for the current one
+func authorize(r *http.Request, action, bucketName, objectKey, versionID string) error {
+	if credentials == nil {
+		return nil
+	}
+	identity := identityFromRequest(r)
+	if identity != nil && identity.userName == "" {
+		return nil
+	}
+
+	resource := "arn:aws:s3:::*"
+	if bucketName != "" {
+		resource = resourceARN(bucketName, objectKey)
+	}
+	context := policyContext(r, bucketName, objectKey, versionID)
+	principal := ""
+	decision := policyNoMatch
+	if identity != nil {
+		principal = userARN(identity.userName)
+		registry.mu.RLock()
+		user, exists := registry.users[identity.userName]
+		registry.mu.RUnlock()
+		if exists && user.policy != nil {
+			decision = user.policy.evaluate(principal, action, resource, context)
+		}
+	}
+
+	if bucketName != "" {
+		acl, bucketPolicy, err := accessControlOf(bucketName, objectKey, versionID, action)
+		if err != nil {
+			return err
+		}
+		if bucketPolicy != nil {
+			decision = max(decision, bucketPolicy.evaluate(principal, action, resource, context))
+		}
+		if decision == policyNoMatch && cannedACLAllows(acl, action, identity != nil) {
+			decision = policyAllowed
+		}
+	}
+	if decision != policyAllowed {
+		return ErrAccessDenied
+	}
+	return nil
+}
+
+// Values of the condition keys for the request on the bucket or on its object.
+// Request tags are the ones of the x-amz-tagging header, existing tags are the ones of the requested object version
+func policyContext(r *http.Request, bucketName, objectKey, versionID string) map[string]string {
+	context := map[string]string{}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		context[conditionSourceIP] = host
+	}
+	query := r.URL.Query()
+	if query.Has("prefix") {
+		context[conditionPrefix] = query.Get("prefix")
+	}
+	if tags, err := tagsFromRequest(r); err == nil {
+		for key, value := range tags {
+			context[conditionRequestObjectTag+key] = value
+		}
+	}
+	if bucketName != "" && objectKey != "" {
+		if object, err := lookupObject(bucketName, objectKey, versionID); err == nil {
+			for key, value := range object.tags {
+				context[conditionExistingObjectTag+key] = value
+			}
+		}
+	}
+	return context
+}
+
+// Returns the action checked before the request is dispatched.
+// Empty action means the handler checks every object it touches itself
+func requestAction(r *http.Request, URLSegments []string) string {
+	query := r.URL.Query()
+	switch len(URLSegments) {
+	case 0:
+		return "s3:ListAllMyBuckets"
+	case 1:
+		if query.Has("policy") {
+			switch r.Method {
+			case http.MethodGet:
+				return "s3:GetBucketPolicy"
+			case http.MethodPut:
+				return "s3:PutBucketPolicy"
+			case http.MethodDelete:
+				return "s3:DeleteBucketPolicy"
+			}
+		}
+		if query.Has("lifecycle") {
+			switch r.Method {
+			case http.MethodGet:
+				return "s3:GetLifecycleConfiguration"
+			case http.MethodPut, http.MethodDelete:
+				return "s3:PutLifecycleConfiguration"
+			}
+		}
+		if query.Has("cors") {
+			switch r.Method {
+			case http.MethodGet:
+				return "s3:GetBucketCORS"
+			case http.MethodPut, http.MethodDelete:
+				return "s3:PutBucketCORS"
+			}
+		}
+		if query.Has("website") {
+			switch r.Method {
+			case http.MethodGet:
+				return "s3:GetBucketWebsite"
+			case http.MethodPut:
+				return "s3:PutBucketWebsite"
+			case http.MethodDelete:
+				return "s3:DeleteBucketWebsite"
+			}
+		}
+		if query.Has("versioning") {
+			switch r.Method {
+			case http.MethodGet:
+				return "s3:GetBucketVersioning"
+			case http.MethodPut:
+				return "s3:PutBucketVersioning"
+			}
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if query.Has("uploads") {
+				return "s3:ListBucketMultipartUploads"
+			} else if query.Has("versions") {
+				return "s3:ListBucketVersions"
+			}
+			return "s3:ListBucket"
+		case http.MethodHead:
+			return "s3:ListBucket"
+		case http.MethodPut:
+			return "s3:CreateBucket"
+		case http.MethodDelete:
+			return "s3:DeleteBucket"
+		case http.MethodPost:
+			// DeleteObjects checks s3:DeleteObject for every key
+			return ""
+		}
+	case 2:
+		if query.Has("tagging") {
+			version := ""
+			if query.Get("versionId") != "" {
+				version = "Version"
+			}
+			switch r.Method {
+			case http.MethodGet:
+				return "s3:GetObject" + version + "Tagging"
+			case http.MethodPut:
+				return "s3:PutObject" + version + "Tagging"
+			case http.MethodDelete:
+				return "s3:DeleteObject" + version + "Tagging"
+			}
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if query.Has("uploadId") {
+				return "s3:ListMultipartUploadParts"
+			} else if query.Get("versionId") != "" {
+				return "s3:GetObjectVersion"
+			}
+			return "s3:GetObject"
+		case http.MethodHead:
+			if query.Get("versionId") != "" {
+				return "s3:GetObjectVersion"
+			}
+			return "s3:GetObject"
+		case http.MethodPut, http.MethodPost:
+			return "s3:PutObject"
+		case http.MethodDelete:
+			if query.Has("uploadId") {
+				return "s3:AbortMultipartUpload"
+			} else if query.Get("versionId") != "" {
+				return "s3:DeleteObjectVersion"
+			}
+			return "s3:DeleteObject"
+		}
+	}
+	return ""
+}
+
+// Generates a random access key ID like AKIA followed by 16 base32 characters and a 40 characters secret
+func generateAccessKey() (string, string, error) {
+	random := make([]byte, 40)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", "", fmt.Errorf("error while generating access key: %w", err)
+	}
+	accessKeyID := "AKIA" + base32.StdEncoding.EncodeToString(random[:10])
+	secretKey := base64.RawURLEncoding.EncodeToString(random[10:])
+	return accessKeyID, secretKey[:40], nil
+}
+
+type listedUser struct {
+	UserName   string   `xml:"UserName"`
+	CreateDate string   `xml:"CreateDate"`
+	AccessKeys []string `xml:"AccessKeys>AccessKeyId"`
+	HasPolicy  bool     `xml:"HasPolicy"`
+}
+
+type listUsersResult struct {
+	XMLName xml.Name     `xml:"ListUsersResult"`
+	Users   []listedUser `xml:"Users>User"`
+}
+
+type createAccessKeyResult struct {
+	XMLName         xml.Name `xml:"CreateAccessKeyResult"`
+	UserName        string   `xml:"UserName"`
+	AccessKeyID     string   `xml:"AccessKeyId"`
+	SecretAccessKey string   `xml:"SecretAccessKey"`
+	CreateDate      string   `xml:"CreateDate"`
+}
+
+// /_admin/users routes, available to the root keys only:
+//
+//	GET    /_admin/users                             lists the users
+//	PUT    /_admin/users/<user>                      creates the user, the body may carry its policy
+//	DELETE /_admin/users/<user>                      deletes the user with its keys
+//	GET    /_admin/users/<user>/policy               returns the policy
+//	PUT    /_admin/users/<user>/policy               replaces the policy
+//	DELETE /_admin/users/<user>/policy               removes the policy
+//	POST   /_admin/users/<user>/access-keys          creates an access key
+//	DELETE /_admin/users/<user>/access-keys/<key>    deletes the access key
+func manageUsers(w http.ResponseWriter, r *http.Request, segments []string) error {
+	identity := identityFromRequest(r)
+	if credentials == nil {
+		return ErrAuthenticationDisabled
+	} else if identity == nil || identity.userName != "" {
+		return ErrAccessDenied
+	}
+
+	if len(segments) == 0 {
+		if r.Method != http.MethodGet {
+			return ErrMethodNotAllowed
+		}
+		return listUsers(w)
+	}
+	userName := segments[0]
+	if !userNamePattern.MatchString(userName) {
+		return ErrInvalidUserName
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodPut:
+		return createUser(r, userName)
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		return deleteUser(userName)
+	case len(segments) == 2 && segments[1] == "policy":
+		return manageUserPolicy(w, r, userName)
+	case len(segments) == 2 && segments[1] == "access-keys" && r.Method == http.MethodPost:
+		return createUserAccessKey(w, userName)
+	case len(segments) == 3 && segments[1] == "access-keys" && r.Method == http.MethodDelete:
+		return deleteUserAccessKey(userName, segments[2])
+	case len(segments) <= 3:
+		return ErrMethodNotAllowed
+	default:
+		return ErrNoSuchResource
+	}
+}
+
+func listUsers(w http.ResponseWriter) error {
+	registry.mu.RLock()
+	result := listUsersResult{}
+	for _, user := range registry.users {
+		listed := listedUser{UserName: user.name, CreateDate: user.created, HasPolicy: user.policy != nil}
+		for _, key := range registry.keys {
+			if key.userName == user.name {
+				listed.AccessKeys = append(listed.AccessKeys, key.accessKeyID)
+			}
+		}
+		sort.Strings(listed.AccessKeys)
+		result.Users = append(result.Users, listed)
+	}
+	registry.mu.RUnlock()
+	sort.Slice(result.Users, func(i, j int) bool {
+		return result.Users[i].UserName < result.Users[j].UserName
+	})
+
+	marshalledObject, err := xml.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the users: %w", err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+func createUser(r *http.Request, userName string) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, bytesIn1mb))
+	if err != nil {
+		return fmt.Errorf("error while reading the policy of <%s> user: %w", userName, err)
+	}
+	user := &iamUser{name: userName, created: time.Now().UTC().Format(time.RFC3339)}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		user.policy, err = parseUserPolicy(body)
+		if err != nil {
+			return err
+		}
+		user.policyJSON = string(body)
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, exists := registry.users[userName]; exists {
+		return ErrUserAlreadyExists
+	}
+	registry.users[userName] = user
+	err = registry.save()
+	if err != nil {
+		delete(registry.users, userName)
+		return err
+	}
+	log.Printf("<%s> user created", userName)
+	return nil
+}
+
+func deleteUser(userName string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	user, exists := registry.users[userName]
+	if !exists {
+		return ErrNoSuchUser
+	}
+	delete(registry.users, userName)
+	removedKeys := map[string]*accessKey{}
+	for keyID, key := range registry.keys {
+		if key.userName == userName {
+			removedKeys[keyID] = key
+			delete(registry.keys, keyID)
+		}
+	}
+	err := registry.save()
+	if err != nil {
+		registry.users[userName] = user
+		for keyID, key := range removedKeys {
+			registry.keys[keyID] = key
+		}
+		return err
+	}
+	log.Printf("<%s> user deleted", userName)
+	return nil
+}
+
+func manageUserPolicy(w http.ResponseWriter, r *http.Request, userName string) error {
+	switch r.Method {
+	case http.MethodGet:
+		registry.mu.RLock()
+		user, exists := registry.users[userName]
+		policyJSON := ""
+		if exists {
+			policyJSON = user.policyJSON
+		}
+		registry.mu.RUnlock()
+		if !exists {
+			return ErrNoSuchUser
+		} else if policyJSON == "" {
+			return ErrNoSuchPolicy
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(policyJSON))
+		return nil
+
+	case http.MethodPut, http.MethodDelete:
+		var policy *policyDocument
+		policyJSON := ""
+		if r.Method == http.MethodPut {
+			body, err := io.ReadAll(io.LimitReader(r.Body, bytesIn1mb))
+			if err != nil {
+				return fmt.Errorf("error while reading the policy of <%s> user: %w", userName, err)
+			}
+			policy, err = parseUserPolicy(body)
+			if err != nil {
+				return err
+			}
+			policyJSON = string(body)
+		}
+
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		user, exists := registry.users[userName]
+		if !exists {
+			return ErrNoSuchUser
+		}
+		previous, previousJSON := user.policy, user.policyJSON
+		user.policy, user.policyJSON = policy, policyJSON
+		err := registry.save()
+		if err != nil {
+			user.policy, user.policyJSON = previous, previousJSON
+			return err
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		log.Printf("policy of <%s> user updated", userName)
+		return nil
+
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+func createUserAccessKey(w http.ResponseWriter, userName string) error {
+	accessKeyID, secretKey, err := generateAccessKey()
+	if err != nil {
+		return err
+	}
+	key := &accessKey{accessKeyID: accessKeyID, secretKey: secretKey, userName: userName, created: time.Now().UTC().Format(time.RFC3339)}
+
+	registry.mu.Lock()
+	if _, exists := registry.users[userName]; !exists {
+		registry.mu.Unlock()
+		return ErrNoSuchUser
+	}
+	registry.keys[accessKeyID] = key
+	err = registry.save()
+	if err != nil {
+		delete(registry.keys, accessKeyID)
+	}
+	registry.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	marshalledObject, err := xml.MarshalIndent(createAccessKeyResult{
+		UserName:        userName,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretKey,
+		CreateDate:      key.created,
+	}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the access key of <%s> user: %w", userName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	log.Printf("access key %s of <%s> user created", accessKeyID, userName)
+	return nil
+}
+
+func deleteUserAccessKey(userName, accessKeyID string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	key, exists := registry.keys[accessKeyID]
+	if !exists || key.userName != userName {
+		return ErrNoSuchAccessKey
+	}
+	delete(registry.keys, accessKeyID)
+	err := registry.save()
+	if err != nil {
+		registry.keys[accessKeyID] = key
+		return err
+	}
+	log.Printf("access key %s of <%s> user deleted", accessKeyID, userName)
+	return nil
+}
@@ -0,0 +1,147 @@
+package web
+
+import (
+	"sort"
+)
+
+// Maximum number of records in a chunk of the object index
+const maxIndexChunkSize = 512
+
+// objectIndex keeps the metadata records of a bucket ordered by key.
+// Records live in sorted chunks of at most maxIndexChunkSize records, so a lookup is two binary searches
+// and an insert or a removal moves at most one chunk and the list of chunks, not the whole bucket.
+// The index is guarded by the bucket lock
+type objectIndex struct {
+	chunks [][]bucketObject
+	count  int
+}
+
+// Builds the index from unordered records, the last record of a key wins
+func newObjectIndex(objects []bucketObject) *objectIndex {
+	sorted := make([]bucketObject, len(objects))
+	copy(sorted, objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].objectKey < sorted[j].objectKey
+	})
+
+	index := &objectIndex{}
+	// Chunks are filled by half to leave room for inserts
+	var chunk []bucketObject
+	for i, object := range sorted {
+		if i+1 < len(sorted) && sorted[i+1].objectKey == object.objectKey {
+			continue
+		}
+		if len(chunk) == maxIndexChunkSize/2 {
+			index.chunks = append(index.chunks, chunk)
+			chunk = nil
+		}
+		chunk = append(chunk, object)
+		index.count++
+	}
+	if len(chunk) > 0 {
+		index.chunks = append(index.chunks, chunk)
+	}
+	return index
+}
+
+// Number of records in the index
+func (index *objectIndex) len() int {
+	return index.count
+}
+
+// Returns the position of the first record whose key is greater than or equal to the key,
+// the chunk is len(index.chunks) if there is no such record
+func (index *objectIndex) search(key string) (int, int) {
+	chunkIdx := sort.Search(len(index.chunks), func(i int) bool {
+		chunk := index.chunks[i]
+		return chunk[len(chunk)-1].objectKey >= key
+	})
+	if chunkIdx == len(index.chunks) {
+		return chunkIdx, 0
+	}
+	chunk := index.chunks[chunkIdx]
+	return chunkIdx, sort.Search(len(chunk), func(i int) bool {
+		return chunk[i].objectKey >= key
+	})
+}
+
+// Returns the record of the key
+func (index *objectIndex) find(key string) (bucketObject, bool) {
+	chunkIdx, pos := index.search(key)
+	if chunkIdx == len(index.chunks) || index.chunks[chunkIdx][pos].objectKey != key {
+		return bucketObject{}, false
+	}
+	return index.chunks[chunkIdx][pos], true
+}
+
+// Replaces the record of the object's key or inserts it
+func (index *objectIndex) set(object bucketObject) {
+	chunkIdx, pos := index.search(object.objectKey)
+	if chunkIdx == len(index.chunks) {
+		// Key is greater than every key, it goes to the end of the last chunk
+		if chunkIdx == 0 {
+			index.chunks = [][]bucketObject{{object}}
+			index.count++
+			return
+		}
+		chunkIdx--
+		pos = len(index.chunks[chunkIdx])
+	} else if index.chunks[chunkIdx][pos].objectKey == object.objectKey {
+		index.chunks[chunkIdx][pos] = object
+		return
+	}
+
+	chunk := append(index.chunks[chunkIdx], bucketObject{})
+	copy(chunk[pos+1:], chunk[pos:])
+	chunk[pos] = object
+	index.count++
+	if len(chunk) <= maxIndexChunkSize {
+		index.chunks[chunkIdx] = chunk
+		return
+	}
+
+	// Full chunk is split in two halves
+	half := len(chunk) / 2
+	right := append([]bucketObject{}, chunk[half:]...)
+	index.chunks = append(index.chunks, nil)
+	copy(index.chunks[chunkIdx+2:], index.chunks[chunkIdx+1:])
+	index.chunks[chunkIdx] = chunk[:half]
+	index.chunks[chunkIdx+1] = right
+}
+
+// Removes the record of the key and returns it
+func (index *objectIndex) remove(key string) (bucketObject, bool) {
+	chunkIdx, pos := index.search(key)
+	if chunkIdx == len(index.chunks) || index.chunks[chunkIdx][pos].objectKey != key {
+		return bucketObject{}, false
+	}
+
+	chunk := index.chunks[chunkIdx]
+	object := chunk[pos]
+	copy(chunk[pos:], chunk[pos+1:])
+	// Release the moved out record
+	chunk[len(chunk)-1] = bucketObject{}
+	chunk = chunk[:len(chunk)-1]
+	index.count--
+
+	if len(chunk) == 0 {
+		copy(index.chunks[chunkIdx:], index.chunks[chunkIdx+1:])
+		index.chunks[len(index.chunks)-1] = nil
+		index.chunks = index.chunks[:len(index.chunks)-1]
+	} else {
+		index.chunks[chunkIdx] = chunk
+	}
+	return object, true
+}
+
+// Calls fn for the records whose keys are greater than or equal to from in key order until it returns false
+func (index *objectIndex) ascend(from string, fn func(object bucketObject) bool) {
+	chunkIdx, pos := index.search(from)
+	for ; chunkIdx < len(index.chunks); chunkIdx, pos = chunkIdx+1, 0 {
+		for _, object := range index.chunks[chunkIdx][pos:] {
+			if !fn(object) {
+				return
+			}
+		}
+	}
+}
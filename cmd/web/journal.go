@@ -0,0 +1,455 @@
+package web
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Write-ahead journal of the storage root
+const journalFileName = "journal.log"
+
+// Operations of the journal entries
+const (
+	journalPutObject    = "put"
+	journalDeleteObject = "delete"
+	journalCreateBucket = "create-bucket"
+	journalDeleteBucket = "delete-bucket"
+	// Noncurrent versions, see applyVersionEntries
+	journalArchiveObject = "archive"
+	journalRemoveVersion = "remove-version"
+	journalRestoreObject = "restore"
+)
+
+// The journal file is truncated once it has this many records and no transaction is in progress
+const maxJournalRecords = 4096
+
+// metadataJournal records the mutations before they touch the backend, so a crash between writing
+// the content and persisting the metadata is repaired on the next start. A transaction is a group of entries:
+//
+//	begin,<id>,<operation>,<bucket>[,<objects.csv record of the put or versioned object>]
+//	begin,<id>,delete,<bucket>,<key of the deleted object>[,<version id of the deleted object>]
+//	end,<id>
+//
+// Begin records are synced before the mutation, end records are not: replaying a finished
+// transaction finds nothing to repair
+type metadataJournal struct {
+	mu      sync.Mutex
+	file    *os.File
+	nextID  uint64
+	records int
+	// Transactions which began and did not end yet
+	inProgress map[uint64]bool
+}
+
+type journalEntry struct {
+	operation  string
+	bucketName string
+	// Record of the put or versioned object, only the key and the version ID for a deleted object
+	object bucketObject
+}
+
+// journalTransaction is a transaction found unfinished when the journal was opened
+type journalTransaction struct {
+	id      uint64
+	entries []journalEntry
+}
+
+// Global journal, set by Init. A nil journal records nothing and transaction 0 is never recorded
+var journal *metadataJournal
+
+func putObjectEntry(bucketName string, object bucketObject) journalEntry {
+	return journalEntry{operation: journalPutObject, bucketName: bucketName, object: object}
+}
+
+func deleteObjectEntry(bucketName, objectKey string) journalEntry {
+	return journalEntry{operation: journalDeleteObject, bucketName: bucketName, object: bucketObject{objectKey: objectKey}}
+}
+
+// Entry of an operation on a version of the object, the deleted current version among them
+func versionEntry(operation, bucketName string, version bucketObject) journalEntry {
+	return journalEntry{operation: operation, bucketName: bucketName, object: version}
+}
+
+func bucketEntry(operation, bucketName string) journalEntry {
+	return journalEntry{operation: operation, bucketName: bucketName}
+}
+
+// Opens the journal and returns the transactions which did not end, a record torn by a crash is dropped
+func openJournal(path string) (*metadataJournal, []journalTransaction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("error while reading the journal: %w", err)
+	}
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+
+	j := &metadataJournal{nextID: 1, inProgress: map[uint64]bool{}}
+	transactions := map[uint64]*journalTransaction{}
+	journalCsvReader := csv.NewReader(bytes.NewReader(data))
+	journalCsvReader.FieldsPerRecord = -1
+	for {
+		record, err := journalCsvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error while reading the journal: %w", err)
+		}
+		if len(record) < 2 {
+			return nil, nil, ErrInvalidNumberOfFields
+		}
+		id, err := strconv.ParseUint(record[1], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error while parsing the journal transaction id: %w", err)
+		}
+		j.records++
+		j.nextID = max(j.nextID, id+1)
+
+		if record[0] == "end" {
+			delete(transactions, id)
+			continue
+		}
+		if record[0] != "begin" || len(record) < 4 {
+			return nil, nil, ErrInvalidNumberOfFields
+		}
+		entry := journalEntry{operation: record[2], bucketName: record[3]}
+		switch entry.operation {
+		case journalPutObject, journalArchiveObject, journalRemoveVersion, journalRestoreObject:
+			entry.object, err = decodeObjectRecord(record[4:])
+			if err != nil {
+				return nil, nil, err
+			}
+		case journalDeleteObject:
+			if len(record) < 5 {
+				return nil, nil, ErrInvalidNumberOfFields
+			}
+			entry.object.objectKey = record[4]
+			if len(record) > 5 {
+				entry.object.versionID = record[5]
+			}
+		}
+		transaction, exists := transactions[id]
+		if !exists {
+			transaction = &journalTransaction{id: id}
+			transactions[id] = transaction
+		}
+		transaction.entries = append(transaction.entries, entry)
+	}
+
+	j.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while opening the journal: %w", err)
+	}
+
+	unfinished := make([]journalTransaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		unfinished = append(unfinished, *transaction)
+	}
+	sort.Slice(unfinished, func(i, k int) bool {
+		return unfinished[i].id < unfinished[k].id
+	})
+	return j, unfinished, nil
+}
+
+// Durably records the entries before they are applied and returns the transaction id
+func (j *metadataJournal) begin(entries ...journalEntry) (uint64, error) {
+	if j == nil || len(entries) == 0 {
+		return 0, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	id := j.nextID
+	j.nextID++
+	var buffer bytes.Buffer
+	csvWriter := csv.NewWriter(&buffer)
+	for _, entry := range entries {
+		record := []string{"begin", strconv.FormatUint(id, 10), entry.operation, entry.bucketName}
+		switch entry.operation {
+		case journalPutObject, journalArchiveObject, journalRemoveVersion, journalRestoreObject:
+			record = append(record, encodeObjectRecord(entry.object)...)
+		case journalDeleteObject:
+			record = append(record, entry.object.objectKey)
+			if entry.object.versionID != "" {
+				record = append(record, entry.object.versionID)
+			}
+		}
+		csvWriter.Write(record)
+	}
+	csvWriter.Flush()
+
+	_, err := j.file.Write(buffer.Bytes())
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error while writing the journal: %w", err)
+	}
+	j.records += len(entries)
+	j.inProgress[id] = true
+	return id, nil
+}
+
+// Records that the transaction is applied, the journal is truncated when nothing is in progress
+func (j *metadataJournal) end(id uint64) {
+	if j == nil || id == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finish(id)
+}
+
+// Ends the transaction which failed before it ended, so it does not keep the journal from being truncated.
+// It is deferred right after begin and does nothing once the transaction ended
+func (j *metadataJournal) abort(id uint64) {
+	if j == nil || id == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.inProgress[id] {
+		return
+	}
+	log.Printf("journal transaction %d aborted", id)
+	j.finish(id)
+}
+
+// Writes the end record of the transaction or truncates the journal when nothing is left in progress,
+// the caller must hold the journal lock
+func (j *metadataJournal) finish(id uint64) {
+	delete(j.inProgress, id)
+	if len(j.inProgress) == 0 && j.records >= maxJournalRecords {
+		err := j.truncate()
+		if err != nil {
+			log.Printf("error while truncating the journal: %s", err)
+		}
+		return
+	}
+	_, err := fmt.Fprintf(j.file, "end,%d\n", id)
+	if err != nil {
+		log.Printf("error while writing the journal: %s", err)
+		return
+	}
+	j.records++
+}
+
+// Drops every record, the caller must hold the journal lock and no transaction must be in progress
+func (j *metadataJournal) truncate() error {
+	err := j.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	j.records = 0
+	return j.file.Sync()
+}
+
+// Repairs the storage after the transactions which did not end and truncates the journal.
+// Puts whose content was committed get their metadata record, puts whose content is missing
+// lose it, deletes and version operations are completed, created buckets which were not persisted
+// are removed and deleted buckets are removed for good
+func recoverJournal(transactions []journalTransaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	var rolledForward, droppedRecords, completedDeletes, repairedBuckets int
+	changes := map[string][]objectChange{}
+	// Version operations persist right away, so the batched changes of their bucket are persisted before them
+	flushChanges := func(bucket *bucketData) error {
+		if len(changes[bucket.Name]) == 0 {
+			return nil
+		}
+		err := saveObjectsData(bucket, changes[bucket.Name]...)
+		if err != nil {
+			return fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucket.Name, err)
+		}
+		delete(changes, bucket.Name)
+		return nil
+	}
+	versionedBuckets := map[string]bool{}
+	for _, transaction := range transactions {
+		for entryIdx, entry := range transaction.entries {
+			bucket, exists := store.buckets[entry.bucketName]
+			switch entry.operation {
+			case journalPutObject:
+				if !exists {
+					continue
+				}
+				committed, contentExists, err := objectContentMatches(entry.bucketName, entry.object)
+				if err != nil {
+					return err
+				}
+				current, recorded := bucket.objects.find(entry.object.objectKey)
+				switch {
+				case committed:
+					if !recorded || current.etag != entry.object.etag || current.lastModified != entry.object.lastModified {
+						bucket.objects.set(entry.object)
+						changes[bucket.Name] = append(changes[bucket.Name], objectChange{object: entry.object})
+						rolledForward++
+					}
+				case !contentExists && recorded:
+					bucket.objects.remove(entry.object.objectKey)
+					changes[bucket.Name] = append(changes[bucket.Name], removedObjectChange(entry.object.objectKey))
+					droppedRecords++
+				}
+
+			case journalDeleteObject:
+				if !exists {
+					continue
+				}
+				// Another version became current after the delete
+				current, recorded := bucket.objects.find(entry.object.objectKey)
+				if recorded && current.versionID != entry.object.versionID {
+					continue
+				}
+				restored, err := restoredInTransaction(transaction.entries[entryIdx+1:], entry.object.objectKey)
+				if err != nil {
+					return err
+				}
+				// Content in place is the restored version once the restore moved it
+				if !restored {
+					err = backend.RemoveObject(entry.bucketName, entry.object.objectKey)
+					if err != nil {
+						return fmt.Errorf("error while removing <%s> object in <%s> bucket: %w", entry.object.objectKey, entry.bucketName, err)
+					}
+				}
+				if _, recorded := bucket.objects.remove(entry.object.objectKey); recorded {
+					changes[bucket.Name] = append(changes[bucket.Name], removedObjectChange(entry.object.objectKey))
+					completedDeletes++
+				}
+
+			case journalArchiveObject, journalRemoveVersion, journalRestoreObject:
+				if !exists {
+					continue
+				}
+				err := flushChanges(bucket)
+				if err != nil {
+					return err
+				}
+				err = applyVersionEntries(bucket, []journalEntry{entry})
+				if err != nil {
+					return err
+				}
+				versionedBuckets[bucket.Name] = true
+
+			case journalCreateBucket, journalDeleteBucket:
+				if exists {
+					// Created bucket was persisted or deleted bucket still has objects
+					if entry.operation == journalCreateBucket || bucket.objects.len() > 0 || len(bucket.versions) > 0 {
+						continue
+					}
+					delete(store.buckets, entry.bucketName)
+					delete(changes, entry.bucketName)
+					err := saveBucketsData()
+					if err != nil {
+						return fmt.Errorf("error while saving buckets metadata: %w", err)
+					}
+					repairedBuckets++
+				}
+				// Storage of the bucket which is not in the metadata is removed
+				err := backend.DeleteBucket(entry.bucketName)
+				switch {
+				case err == nil:
+					if !exists {
+						repairedBuckets++
+					}
+				case errors.Is(err, os.ErrNotExist), err == ErrBucketNotExists:
+				default:
+					log.Printf("leftover of <%s> bucket is not removed: %s", entry.bucketName, err)
+				}
+			}
+		}
+	}
+
+	for bucketName, bucketChanges := range changes {
+		err := saveObjectsData(store.buckets[bucketName], bucketChanges...)
+		if err != nil {
+			return fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucketName, err)
+		}
+	}
+
+	// Versions archived by puts whose content was never committed are still the current objects
+	for bucketName := range versionedBuckets {
+		bucket, exists := store.buckets[bucketName]
+		if !exists {
+			continue
+		}
+		entries := []journalEntry{}
+		for objectKey, list := range bucket.versions {
+			current, recorded := bucket.objects.find(objectKey)
+			if idx, exists := findVersion(list, current.versionID); recorded && exists && !list[idx].deleteMarker {
+				entries = append(entries, versionEntry(journalRemoveVersion, bucketName, list[idx]))
+			}
+		}
+		err := applyVersionEntries(bucket, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	err := journal.truncate()
+	if err != nil {
+		return fmt.Errorf("error while truncating the journal: %w", err)
+	}
+	log.Printf("journal recovery: %d unfinished transactions, %d objects rolled forward, %d records of missing objects dropped, %d deletes completed, %d buckets repaired",
+		len(transactions), rolledForward, droppedRecords, completedDeletes, repairedBuckets)
+	return nil
+}
+
+// Reports whether a restore among the entries already moved a version of the key into place
+func restoredInTransaction(entries []journalEntry, objectKey string) (bool, error) {
+	for _, entry := range entries {
+		if entry.operation != journalRestoreObject || entry.object.objectKey != objectKey {
+			continue
+		}
+		versionReader, err := backend.OpenVersion(entry.bucketName, objectKey, entry.object.versionID)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return true, nil
+			}
+			return false, fmt.Errorf("error while opening <%s> version of <%s> object: %w", displayVersionID(entry.object.versionID), objectKey, err)
+		}
+		versionReader.Close()
+		return false, nil
+	}
+	return false, nil
+}
+
+// Reports whether the stored content is the one of the record and whether there is any content.
+// Contents of multipart uploads are only compared by length, their ETags are not digests of the content
+func objectContentMatches(bucketName string, object bucketObject) (bool, bool, error) {
+	objectReader, err := backend.OpenObject(bucketName, object.objectKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("error while opening <%s> object in <%s> bucket: %w", object.objectKey, bucketName, err)
+	}
+	defer objectReader.Close()
+
+	if objectReader.Size() != int64(object.contentLength) {
+		return false, true, nil
+	}
+	if object.etag == "" || strings.Contains(object.etag, "-") {
+		return true, true, nil
+	}
+	hasher := md5.New()
+	_, err = io.Copy(hasher, objectReader)
+	if err != nil {
+		return false, true, fmt.Errorf("error while reading <%s> object in <%s> bucket: %w", object.objectKey, bucketName, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)) == object.etag, true, nil
+}
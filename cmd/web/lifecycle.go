@@ -0,0 +1,485 @@
+package web
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrNoSuchLifecycleConfiguration = errors.New("the lifecycle configuration does not exist")
+	ErrInvalidLifecycleDays         = errors.New("lifecycle days must be positive integers")
+	ErrInvalidLifecycleDate         = errors.New("lifecycle date must be midnight UTC in ISO 8601 format")
+	ErrInvalidLifecycleRuleID       = errors.New("lifecycle rule IDs must be unique and at most 255 characters long")
+	ErrNoLifecycleAction            = errors.New("lifecycle rule must specify at least one action")
+	ErrLifecycleUploadsTagFilter    = errors.New("AbortIncompleteMultipartUpload cannot be specified with tags")
+)
+
+// Maximum number of rules in one lifecycle configuration
+const maxLifecycleRules = 1000
+
+// Statuses of a lifecycle rule
+const (
+	lifecycleRuleEnabled  = "Enabled"
+	lifecycleRuleDisabled = "Disabled"
+)
+
+type lifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []lifecycleRule `xml:"Rule"`
+}
+
+type lifecycleRule struct {
+	ID string `xml:"ID,omitempty"`
+	// Prefix outside of the filter is the older form of the prefix filter
+	Prefix                         *string                         `xml:"Prefix"`
+	Filter                         *lifecycleFilter                `xml:"Filter"`
+	Status                         string                          `xml:"Status"`
+	Expiration                     *lifecycleExpiration            `xml:"Expiration"`
+	NoncurrentVersionExpiration    *noncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration"`
+	AbortIncompleteMultipartUpload *abortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload"`
+}
+
+// lifecycleFilter selects the objects of the rule by one of the prefix, the tag or both of them,
+// an empty filter selects all objects
+type lifecycleFilter struct {
+	Prefix *string       `xml:"Prefix"`
+	Tag    *objectTag    `xml:"Tag"`
+	And    *lifecycleAnd `xml:"And"`
+}
+
+type lifecycleAnd struct {
+	Prefix string      `xml:"Prefix,omitempty"`
+	Tags   []objectTag `xml:"Tag"`
+}
+
+// Current objects expire the given number of days after they were written or on the date
+type lifecycleExpiration struct {
+	Days int    `xml:"Days,omitempty"`
+	Date string `xml:"Date,omitempty"`
+}
+
+// Noncurrent versions expire the given number of days after they became noncurrent
+type noncurrentVersionExpiration struct {
+	NoncurrentDays int `xml:"NoncurrentDays"`
+}
+
+type abortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+// Parses and validates the lifecycle configuration
+func parseLifecycle(data []byte) (*lifecycleConfiguration, error) {
+	lifecycle := &lifecycleConfiguration{}
+	err := xml.Unmarshal(data, lifecycle)
+	if err != nil || len(lifecycle.Rules) == 0 || len(lifecycle.Rules) > maxLifecycleRules {
+		return nil, ErrMalformedXML
+	}
+
+	ruleIDs := map[string]bool{}
+	for _, rule := range lifecycle.Rules {
+		if rule.ID != "" && (ruleIDs[rule.ID] || len(rule.ID) > 255) {
+			return nil, ErrInvalidLifecycleRuleID
+		}
+		ruleIDs[rule.ID] = true
+
+		if rule.Status != lifecycleRuleEnabled && rule.Status != lifecycleRuleDisabled {
+			return nil, ErrMalformedXML
+		}
+		// Filter is either the older prefix or the filter element with at most one condition
+		if rule.Prefix != nil && rule.Filter != nil {
+			return nil, ErrMalformedXML
+		}
+		if filter := rule.Filter; filter != nil {
+			conditions := 0
+			for _, set := range []bool{filter.Prefix != nil, filter.Tag != nil, filter.And != nil} {
+				if set {
+					conditions++
+				}
+			}
+			if conditions > 1 {
+				return nil, ErrMalformedXML
+			}
+			// And combines at least two conditions, the prefix and each of the tags count as one
+			if and := filter.And; and != nil {
+				andConditions := len(and.Tags)
+				if and.Prefix != "" {
+					andConditions++
+				}
+				if andConditions < 2 {
+					return nil, ErrMalformedXML
+				}
+			}
+		}
+
+		if rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil && rule.AbortIncompleteMultipartUpload == nil {
+			return nil, ErrNoLifecycleAction
+		}
+		if expiration := rule.Expiration; expiration != nil {
+			switch {
+			case expiration.Days != 0 && expiration.Date != "":
+				return nil, ErrMalformedXML
+			case expiration.Date != "":
+				date, err := time.Parse(time.RFC3339, expiration.Date)
+				if err != nil || !date.Equal(date.UTC().Truncate(24*time.Hour)) {
+					return nil, ErrInvalidLifecycleDate
+				}
+			case expiration.Days <= 0:
+				return nil, ErrInvalidLifecycleDays
+			}
+		}
+		if expiration := rule.NoncurrentVersionExpiration; expiration != nil && expiration.NoncurrentDays <= 0 {
+			return nil, ErrInvalidLifecycleDays
+		}
+		if abort := rule.AbortIncompleteMultipartUpload; abort != nil {
+			if abort.DaysAfterInitiation <= 0 {
+				return nil, ErrInvalidLifecycleDays
+			} else if len(rule.tags()) > 0 {
+				return nil, ErrLifecycleUploadsTagFilter
+			}
+		}
+	}
+	return lifecycle, nil
+}
+
+// Key prefix of the objects selected by the rule
+func (rule lifecycleRule) prefix() string {
+	switch {
+	case rule.Prefix != nil:
+		return *rule.Prefix
+	case rule.Filter == nil:
+		return ""
+	case rule.Filter.Prefix != nil:
+		return *rule.Filter.Prefix
+	case rule.Filter.And != nil:
+		return rule.Filter.And.Prefix
+	}
+	return ""
+}
+
+// Tags the objects selected by the rule must have
+func (rule lifecycleRule) tags() []objectTag {
+	switch {
+	case rule.Filter == nil:
+		return nil
+	case rule.Filter.Tag != nil:
+		return []objectTag{*rule.Filter.Tag}
+	case rule.Filter.And != nil:
+		return rule.Filter.And.Tags
+	}
+	return nil
+}
+
+// Reports whether the rule selects the object with the tags
+func (rule lifecycleRule) matches(objectKey string, tags map[string]string) bool {
+	if rule.Status != lifecycleRuleEnabled || !strings.HasPrefix(objectKey, rule.prefix()) {
+		return false
+	}
+	for _, tag := range rule.tags() {
+		if value, exists := tags[tag.Key]; !exists || value != tag.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// Time when something dated by the time expires after the days, it is rounded up to the next midnight UTC
+// unless it is a midnight already
+func lifecycleDeadline(since time.Time, days int) time.Time {
+	deadline := since.UTC().AddDate(0, 0, days)
+	if midnight := deadline.Truncate(24 * time.Hour); !midnight.Equal(deadline) {
+		return midnight.Add(24 * time.Hour)
+	}
+	return deadline
+}
+
+// Reports whether the current object expired by any of the rules
+func currentObjectExpired(rules []lifecycleRule, object bucketObject, now time.Time) bool {
+	lastModified, err := parseObjectTime(object.lastModified)
+	if err != nil {
+		return false
+	}
+	for _, rule := range rules {
+		if rule.Expiration == nil || !rule.matches(object.objectKey, object.tags) {
+			continue
+		}
+		if rule.Expiration.Date != "" {
+			date, _ := time.Parse(time.RFC3339, rule.Expiration.Date)
+			if !now.Before(date) {
+				return true
+			}
+		} else if !now.Before(lifecycleDeadline(lastModified, rule.Expiration.Days)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the noncurrent version expired by any of the rules, it became noncurrent
+// when its successor was written
+func noncurrentVersionExpired(rules []lifecycleRule, version, successor bucketObject, now time.Time) bool {
+	noncurrentSince, err := parseObjectTime(successor.lastModified)
+	if err != nil {
+		return false
+	}
+	for _, rule := range rules {
+		if rule.NoncurrentVersionExpiration == nil || !rule.matches(version.objectKey, version.tags) {
+			continue
+		}
+		if !now.Before(lifecycleDeadline(noncurrentSince, rule.NoncurrentVersionExpiration.NoncurrentDays)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the multipart upload is abandoned according to any of the rules
+func uploadExpired(rules []lifecycleRule, upload *multipartUpload, now time.Time) bool {
+	initiated, err := parseObjectTime(upload.initiated)
+	if err != nil {
+		return false
+	}
+	for _, rule := range rules {
+		// Rules aborting uploads never filter by tags
+		if rule.AbortIncompleteMultipartUpload == nil || !rule.matches(upload.objectKey, nil) {
+			continue
+		}
+		if !now.Before(lifecycleDeadline(initiated, rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the lifecycle rules of all buckets every lifecycleInterval, runs forever
+func expireObjects() {
+	ticker := time.NewTicker(lifecycleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		applyLifecycleRules(time.Now())
+	}
+}
+
+// Expires the objects, the noncurrent versions and the multipart uploads selected by the lifecycle rules.
+// In dry run mode they are only logged
+func applyLifecycleRules(now time.Time) {
+	var expired, expiredVersions, abortedUploads int
+	for _, bucketName := range store.names() {
+		store.mu.RLock()
+		bucket, exists := store.buckets[bucketName]
+		var lifecycle *lifecycleConfiguration
+		if exists {
+			lifecycle = bucket.lifecycle
+		}
+		store.mu.RUnlock()
+		if lifecycle == nil {
+			continue
+		}
+
+		objects, versions, err := expireBucketObjects(bucketName, lifecycle.Rules, now)
+		if err != nil {
+			log.Printf("lifecycle of <%s> bucket failed: %s", bucketName, err)
+		}
+		expired += objects
+		expiredVersions += versions
+		abortedUploads += abortExpiredUploads(bucketName, lifecycle.Rules, now)
+	}
+	if lifecycleDryRun {
+		log.Printf("lifecycle dry run: %d objects, %d noncurrent versions and %d multipart uploads would expire", expired, expiredVersions, abortedUploads)
+	} else if expired+expiredVersions+abortedUploads > 0 {
+		log.Printf("lifecycle: %d objects, %d noncurrent versions and %d multipart uploads expired", expired, expiredVersions, abortedUploads)
+	}
+}
+
+// Expires the objects and the noncurrent versions of the bucket. They are selected under the read lock
+// and expired under the write lock if their records did not change meanwhile
+func expireBucketObjects(bucketName string, rules []lifecycleRule, now time.Time) (int, int, error) {
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		return 0, 0, err
+	}
+	objects, versions := []bucketObject{}, []bucketObject{}
+	bucket.objects.ascend("", func(object bucketObject) bool {
+		if currentObjectExpired(rules, object, now) {
+			objects = append(objects, object)
+		}
+		return true
+	})
+	for objectKey, list := range bucket.versions {
+		// Latest version of the key is a delete marker when the key has no current object
+		successor, exists := bucket.objects.find(objectKey)
+		if !exists {
+			successor, list = list[0], list[1:]
+		}
+		for _, version := range list {
+			if noncurrentVersionExpired(rules, version, successor, now) {
+				versions = append(versions, version)
+			}
+			successor = version
+		}
+	}
+	bucket.mu.RUnlock()
+
+	if lifecycleDryRun {
+		for _, object := range objects {
+			log.Printf("lifecycle dry run: <%s> object in <%s> bucket would expire", object.objectKey, bucketName)
+		}
+		for _, version := range versions {
+			log.Printf("lifecycle dry run: <%s> version of <%s> object in <%s> bucket would expire", displayVersionID(version.versionID), version.objectKey, bucketName)
+		}
+		return len(objects), len(versions), nil
+	}
+	if len(objects)+len(versions) == 0 {
+		return 0, 0, nil
+	}
+
+	bucket, err = store.lockBucket(bucketName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer bucket.mu.Unlock()
+	versioning := bucketVersioning(bucket)
+	expired, expiredVersions := 0, 0
+	for _, object := range objects {
+		current, exists := bucket.objects.find(object.objectKey)
+		if !exists || current.versionID != object.versionID || current.lastModified != object.lastModified {
+			continue
+		}
+		_, err = deleteCurrentObject(bucket, versioning, object.objectKey)
+		if err != nil {
+			return expired, expiredVersions, err
+		}
+		log.Printf("lifecycle: <%s> object in <%s> bucket expired", object.objectKey, bucketName)
+		expired++
+	}
+	for _, version := range versions {
+		// Version may have become current again meanwhile
+		if current, exists := bucket.objects.find(version.objectKey); exists && current.versionID == version.versionID {
+			continue
+		}
+		if _, listed := findVersion(bucket.versions[version.objectKey], version.versionID); !listed {
+			continue
+		}
+		_, err = deleteVersion(bucket, versioning, version.objectKey, displayVersionID(version.versionID))
+		if err != nil {
+			return expired, expiredVersions, err
+		}
+		log.Printf("lifecycle: <%s> version of <%s> object in <%s> bucket expired", displayVersionID(version.versionID), version.objectKey, bucketName)
+		expiredVersions++
+	}
+	return expired, expiredVersions, nil
+}
+
+// Aborts the multipart uploads of the bucket abandoned according to the rules
+func abortExpiredUploads(bucketName string, rules []lifecycleRule, now time.Time) int {
+	multipartUploadsMu.Lock()
+	defer multipartUploadsMu.Unlock()
+	aborted := 0
+	for _, upload := range multipartUploads {
+		if upload.bucketName != bucketName || !uploadExpired(rules, upload, now) {
+			continue
+		}
+		if lifecycleDryRun {
+			log.Printf("lifecycle dry run: <%s> multipart upload of <%s> object in <%s> bucket would be aborted", upload.uploadID, upload.objectKey, bucketName)
+			aborted++
+			continue
+		}
+		err := removeMultipartUpload(upload)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		aborted++
+	}
+	return aborted
+}
+
+// GET /<bucket>?lifecycle handler
+func getBucketLifecycle(w http.ResponseWriter, bucketName string) error {
+	store.mu.RLock()
+	bucket, exists := store.buckets[bucketName]
+	var lifecycle *lifecycleConfiguration
+	if exists {
+		lifecycle = bucket.lifecycle
+	}
+	store.mu.RUnlock()
+	if !exists {
+		return ErrBucketNotExists
+	} else if lifecycle == nil {
+		return ErrNoSuchLifecycleConfiguration
+	}
+	marshalledObject, err := xml.MarshalIndent(lifecycle, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the lifecycle of <%s> bucket: %w", bucketName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+// PUT /<bucket>?lifecycle handler, the configuration replaces the previous one
+func putBucketLifecycle(r *http.Request, bucketName string) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, bytesIn1mb))
+	if err != nil {
+		return fmt.Errorf("error while reading the lifecycle of <%s> bucket: %w", bucketName, err)
+	}
+	err = checkContentMD5(r, body)
+	if err != nil {
+		return err
+	}
+	lifecycle, err := parseLifecycle(body)
+	if err != nil {
+		return err
+	}
+	// Configuration is stored in its canonical form
+	lifecycleXML, err := xml.Marshal(lifecycle)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the lifecycle of <%s> bucket: %w", bucketName, err)
+	}
+	err = setBucketLifecycle(bucketName, string(lifecycleXML), lifecycle)
+	if err != nil {
+		return err
+	}
+	log.Printf("lifecycle of <%s> bucket updated", bucketName)
+	return nil
+}
+
+// DELETE /<bucket>?lifecycle handler
+func deleteBucketLifecycle(bucketName string) error {
+	err := setBucketLifecycle(bucketName, "", nil)
+	if err != nil {
+		return err
+	}
+	log.Printf("lifecycle of <%s> bucket deleted", bucketName)
+	return nil
+}
+
+// Replaces the lifecycle of the bucket and persists the buckets metadata
+func setBucketLifecycle(bucketName, lifecycleXML string, lifecycle *lifecycleConfiguration) error {
+	return setBucketConfig(bucketName, func(bucket *bucketData) func() {
+		previousXML, previous := bucket.lifecycleXML, bucket.lifecycle
+		bucket.lifecycleXML, bucket.lifecycle = lifecycleXML, lifecycle
+		return func() {
+			bucket.lifecycleXML, bucket.lifecycle = previousXML, previous
+		}
+	})
+}
+
+// Parses the stored lifecycle configuration, an empty configuration is no configuration
+func loadBucketLifecycle(bucket *bucketData) error {
+	if bucket.lifecycleXML == "" {
+		return nil
+	}
+	lifecycle, err := parseLifecycle([]byte(bucket.lifecycleXML))
+	if err != nil {
+		return fmt.Errorf("error while parsing lifecycle of <%s> bucket: %w", bucket.Name, err)
+	}
+	bucket.lifecycle = lifecycle
+	return nil
+}
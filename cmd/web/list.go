@@ -0,0 +1,217 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrInvalidListType          = errors.New("the specified list-type is not supported, must be 2")
+	ErrInvalidMaxKeys           = errors.New("max-keys must be a non-negative integer")
+	ErrInvalidContinuationToken = errors.New("the continuation token provided is incorrect")
+)
+
+// Default and maximum number of keys returned in one listing page
+const maxListKeys = 1000
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Contents              []listedObject `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listedObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// GET handler
+func listObjects(w http.ResponseWriter, r *http.Request, bucketName string) error {
+	query := r.URL.Query()
+
+	// Only ListObjectsV2 is supported
+	if listType := query.Get("list-type"); listType != "" && listType != "2" {
+		return ErrInvalidListType
+	}
+
+	if _, exists := store.get(bucketName); !exists {
+		return ErrBucketNotExists
+	}
+
+	result := listBucketResult{
+		Name:              bucketName,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		MaxKeys:           maxListKeys,
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+	}
+
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		n, err := strconv.Atoi(maxKeys)
+		if err != nil || n < 0 {
+			return ErrInvalidMaxKeys
+		}
+		if n < maxListKeys {
+			result.MaxKeys = n
+		}
+	}
+
+	// Listing starts after the continuation token if given, otherwise after start-after
+	marker, skipPrefix := result.StartAfter, ""
+	if result.ContinuationToken != "" {
+		decoded, err := decodeContinuationToken(result.ContinuationToken)
+		if err != nil {
+			return err
+		}
+		marker = decoded
+		// Page ended on a common prefix, so all keys under it were already returned
+		if result.Delimiter != "" && strings.HasSuffix(marker, result.Delimiter) {
+			skipPrefix = marker
+		}
+	}
+
+	// Keys are listed in UTF-8 binary order straight from the index, starting at the prefix or after the marker
+	from := result.Prefix
+	if marker > from {
+		from = marker
+	}
+	if skipPrefix != "" && skipPrefix+prefixEnd > from {
+		from = skipPrefix + prefixEnd
+	}
+
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	lastEntry := ""
+	for seeking := true; seeking; {
+		seeking = false
+		bucket.objects.ascend(from, func(object bucketObject) bool {
+			key := object.objectKey
+			// Keys with the prefix are contiguous in the index
+			if !strings.HasPrefix(key, result.Prefix) {
+				return false
+			}
+			// Skip everything up to the marker
+			if key <= marker || (skipPrefix != "" && strings.HasPrefix(key, skipPrefix)) {
+				return true
+			}
+
+			// Roll up keys sharing the same prefix up to the delimiter
+			entry := key
+			isPrefix := false
+			if result.Delimiter != "" {
+				if idx := strings.Index(key[len(result.Prefix):], result.Delimiter); idx != -1 {
+					entry = key[:len(result.Prefix)+idx+len(result.Delimiter)]
+					isPrefix = true
+				}
+			}
+			if isPrefix && entry == lastEntry {
+				return true
+			}
+
+			if result.KeyCount == result.MaxKeys {
+				result.IsTruncated = true
+				return false
+			}
+
+			result.KeyCount++
+			lastEntry = entry
+			if isPrefix {
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+				// Keys under the common prefix are skipped by seeking past them
+				from, seeking = entry+prefixEnd, true
+				return false
+			}
+			result.Contents = append(result.Contents, listedObject{
+				Key:          key,
+				LastModified: formatListTime(object.lastModified),
+				ETag:         listETag(object.etag),
+				Size:         object.contentLength,
+				StorageClass: "STANDARD",
+			})
+			return true
+		})
+	}
+	bucket.mu.RUnlock()
+
+	if result.IsTruncated {
+		result.NextContinuationToken = encodeContinuationToken(lastEntry)
+	}
+
+	marshalledObject, err := xml.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the objects list of <%s> bucket: %w", bucketName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	log.Printf("Objects list of <%s> bucket requested", bucketName)
+	return nil
+}
+
+// Keys are valid UTF-8 which never contains the 0xFF byte,
+// so every key starting with a prefix sorts before the prefix followed by it
+const prefixEnd = "\xff"
+
+// Objects uploaded before ETags were introduced are listed without one
+func listETag(etag string) string {
+	if etag == "" {
+		return ""
+	}
+	return quoteETag(etag)
+}
+
+// Continuation token is an opaque encoding of the last listed key or common prefix
+func encodeContinuationToken(lastEntry string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastEntry))
+}
+
+func decodeContinuationToken(token string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidContinuationToken
+	}
+	return string(decoded), nil
+}
+
+// Object times are stored either in RFC3339 or in RFC822
+func parseObjectTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Parse(time.RFC822, value)
+	}
+	return parsed, nil
+}
+
+// Listing times are in ISO 8601 format with milliseconds
+func formatListTime(value string) string {
+	parsed, err := parseObjectTime(value)
+	if err != nil {
+		return value
+	}
+	return parsed.UTC().Format("2006-01-02T15:04:05.000Z")
+}
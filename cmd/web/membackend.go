@@ -0,0 +1,366 @@
+package web
+
+import (
+	"bytes"
+	"os"
+	"sync"
+)
+
+// memoryBackend keeps the storage in memory, it is used in tests and loses everything on exit
+type memoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	// Staged uploads, key is the upload ID
+	uploads map[string]*memoryUpload
+}
+
+type memoryBucket struct {
+	createdTime      string
+	lastModifiedTime string
+	status           string
+	acl              string
+	policyJSON       string
+	versioning       string
+	lifecycleXML     string
+	corsXML          string
+	websiteXML       string
+	objects          map[string]bucketObject
+	contents         map[string][]byte
+	versions         map[string][]bucketObject
+	// Contents of the noncurrent versions, key is the object key and the version ID joined by a zero byte
+	versionContents map[string][]byte
+}
+
+type memoryUpload struct {
+	upload   multipartUpload
+	contents map[int][]byte
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		buckets: map[string]*memoryBucket{},
+		uploads: map[string]*memoryUpload{},
+	}
+}
+
+func (b *memoryBackend) LoadBuckets() ([]*bucketData, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	buckets := []*bucketData{}
+	for bucketName, bucket := range b.buckets {
+		objects := make([]bucketObject, 0, len(bucket.objects))
+		for _, object := range bucket.objects {
+			objects = append(objects, object)
+		}
+		buckets = append(buckets, &bucketData{
+			Name:             bucketName,
+			CreatedTime:      bucket.createdTime,
+			LastModifiedTime: bucket.lastModifiedTime,
+			Status:           bucket.status,
+			acl:              bucket.acl,
+			policyJSON:       bucket.policyJSON,
+			versioning:       bucket.versioning,
+			lifecycleXML:     bucket.lifecycleXML,
+			corsXML:          bucket.corsXML,
+			websiteXML:       bucket.websiteXML,
+			objects:          newObjectIndex(objects),
+			versions:         copyVersions(bucket.versions),
+		})
+	}
+	return buckets, nil
+}
+
+func (b *memoryBackend) SaveBuckets(buckets []*bucketData) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, saved := range buckets {
+		bucket, exists := b.buckets[saved.Name]
+		if !exists {
+			continue
+		}
+		bucket.createdTime = saved.CreatedTime
+		bucket.status, bucket.lastModifiedTime = saved.stats()
+		bucket.acl = saved.acl
+		bucket.policyJSON = saved.policyJSON
+		bucket.versioning = saved.versioning
+		bucket.lifecycleXML = saved.lifecycleXML
+		bucket.corsXML = saved.corsXML
+		bucket.websiteXML = saved.websiteXML
+	}
+	return nil
+}
+
+func (b *memoryBackend) CreateBucket(bucketName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.buckets[bucketName]; !exists {
+		b.buckets[bucketName] = &memoryBucket{
+			objects:         map[string]bucketObject{},
+			contents:        map[string][]byte{},
+			versions:        map[string][]bucketObject{},
+			versionContents: map[string][]byte{},
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) DeleteBucket(bucketName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, exists := b.buckets[bucketName]
+	if !exists {
+		return ErrBucketNotExists
+	}
+	if len(bucket.contents) > 0 || len(bucket.versionContents) > 0 {
+		return ErrBucketIsNotEmpty
+	}
+	delete(b.buckets, bucketName)
+	for uploadID, staged := range b.uploads {
+		if staged.upload.bucketName == bucketName {
+			delete(b.uploads, uploadID)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) SaveObjects(bucketName string, objects *objectIndex, changes []objectChange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, exists := b.buckets[bucketName]
+	if !exists {
+		return ErrBucketNotExists
+	}
+	for _, change := range changes {
+		if change.removed {
+			delete(bucket.objects, change.object.objectKey)
+		} else {
+			bucket.objects[change.object.objectKey] = change.object
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) OpenObject(bucketName, objectKey string) (ObjectReader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, exists := b.buckets[bucketName]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	content, exists := bucket.contents[objectKey]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	return memoryReader{bytes.NewReader(content)}, nil
+}
+
+func (b *memoryBackend) CreateObject(bucketName, objectKey string) (ObjectWriter, error) {
+	return &memoryWriter{commit: func(content []byte) error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		bucket, exists := b.buckets[bucketName]
+		if !exists {
+			return ErrBucketNotExists
+		}
+		bucket.contents[objectKey] = content
+		return nil
+	}}, nil
+}
+
+func (b *memoryBackend) RemoveObject(bucketName, objectKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if bucket, exists := b.buckets[bucketName]; exists {
+		delete(bucket.contents, objectKey)
+	}
+	return nil
+}
+
+func (b *memoryBackend) SaveVersions(bucketName string, versions map[string][]bucketObject, changes []objectChange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, exists := b.buckets[bucketName]
+	if !exists {
+		return ErrBucketNotExists
+	}
+	bucket.versions = copyVersions(versions)
+	return nil
+}
+
+func (b *memoryBackend) ArchiveObject(bucketName, objectKey, versionID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, exists := b.buckets[bucketName]
+	if !exists {
+		return ErrBucketNotExists
+	}
+	content, exists := bucket.contents[objectKey]
+	if !exists {
+		return os.ErrNotExist
+	}
+	bucket.versionContents[objectKey+"\x00"+versionID] = content
+	return nil
+}
+
+func (b *memoryBackend) RestoreObject(bucketName, objectKey, versionID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, exists := b.buckets[bucketName]
+	if !exists {
+		return ErrBucketNotExists
+	}
+	content, exists := bucket.versionContents[objectKey+"\x00"+versionID]
+	if !exists {
+		// Version restored before is already in place
+		if _, restored := bucket.contents[objectKey]; restored {
+			return nil
+		}
+		return os.ErrNotExist
+	}
+	bucket.contents[objectKey] = content
+	delete(bucket.versionContents, objectKey+"\x00"+versionID)
+	return nil
+}
+
+func (b *memoryBackend) OpenVersion(bucketName, objectKey, versionID string) (ObjectReader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, exists := b.buckets[bucketName]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	content, exists := bucket.versionContents[objectKey+"\x00"+versionID]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	return memoryReader{bytes.NewReader(content)}, nil
+}
+
+func (b *memoryBackend) RemoveVersion(bucketName, objectKey, versionID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if bucket, exists := b.buckets[bucketName]; exists {
+		delete(bucket.versionContents, objectKey+"\x00"+versionID)
+	}
+	return nil
+}
+
+// Copies the lists of the versions, so the backend never shares them with the store
+func copyVersions(versions map[string][]bucketObject) map[string][]bucketObject {
+	copied := make(map[string][]bucketObject, len(versions))
+	for objectKey, list := range versions {
+		copied[objectKey] = append([]bucketObject{}, list...)
+	}
+	return copied
+}
+
+func (b *memoryBackend) LoadUploads(bucketName string) ([]*multipartUpload, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	uploads := []*multipartUpload{}
+	for _, staged := range b.uploads {
+		if staged.upload.bucketName != bucketName {
+			continue
+		}
+		upload := staged.upload
+		upload.parts = map[int]uploadPart{}
+		for partNumber, part := range staged.upload.parts {
+			upload.parts[partNumber] = part
+		}
+		uploads = append(uploads, &upload)
+	}
+	return uploads, nil
+}
+
+func (b *memoryBackend) CreateUpload(upload *multipartUpload) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	staged := &memoryUpload{upload: *upload, contents: map[int][]byte{}}
+	staged.upload.parts = map[int]uploadPart{}
+	b.uploads[upload.uploadID] = staged
+	return nil
+}
+
+func (b *memoryBackend) CreatePart(upload *multipartUpload, partNumber int) (ObjectWriter, error) {
+	return &memoryWriter{commit: func(content []byte) error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		staged, exists := b.uploads[upload.uploadID]
+		if !exists {
+			return ErrNoSuchUpload
+		}
+		staged.contents[partNumber] = content
+		return nil
+	}}, nil
+}
+
+func (b *memoryBackend) SavePart(upload *multipartUpload, part uploadPart) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	staged, exists := b.uploads[upload.uploadID]
+	if !exists {
+		return ErrNoSuchUpload
+	}
+	staged.upload.parts[part.partNumber] = part
+	return nil
+}
+
+func (b *memoryBackend) OpenPart(upload *multipartUpload, partNumber int) (ObjectReader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	staged, exists := b.uploads[upload.uploadID]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	content, exists := staged.contents[partNumber]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	return memoryReader{bytes.NewReader(content)}, nil
+}
+
+func (b *memoryBackend) RemoveUpload(upload *multipartUpload) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.uploads, upload.uploadID)
+	return nil
+}
+
+// memoryReader reads a stored content, contents are never modified once committed
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+// memoryWriter buffers the content until it is committed
+type memoryWriter struct {
+	buffer bytes.Buffer
+	commit func(content []byte) error
+	done   bool
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+func (w *memoryWriter) Close() error {
+	return nil
+}
+
+func (w *memoryWriter) Commit() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	return w.commit(w.buffer.Bytes())
+}
+
+func (w *memoryWriter) Abort() {
+	if !w.done {
+		w.done = true
+		w.buffer.Reset()
+	}
+}
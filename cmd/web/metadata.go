@@ -0,0 +1,96 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Errors
+var (
+	ErrMetadataTooLarge = errors.New("your metadata headers exceed the maximum allowed metadata size")
+)
+
+// Standard headers stored with the object and replayed verbatim on GET and HEAD,
+// Content-Type is kept separately in bucketObject.contentType
+var storedObjectHeaders = []string{
+	"Content-Encoding",
+	"Content-Disposition",
+	"Cache-Control",
+	"Expires",
+}
+
+// Prefix of user defined metadata headers in canonical form
+const userMetadataPrefix = "X-Amz-Meta-"
+
+// Maximum size of the user defined metadata, keys and values together
+const maxUserMetadataSize = 2 * 1024
+
+// Collects the stored headers and user metadata of the request.
+// The aws-chunked content encoding only describes the request body and is not stored
+func objectMetadataFromRequest(header http.Header) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, name := range storedObjectHeaders {
+		value := header.Get(name)
+		if name == "Content-Encoding" {
+			value = stripAWSChunkedEncoding(value)
+		}
+		if value != "" {
+			metadata[name] = value
+		}
+	}
+
+	userMetadataSize := 0
+	for name, values := range header {
+		if !strings.HasPrefix(name, userMetadataPrefix) {
+			continue
+		}
+		value := strings.Join(values, ",")
+		userMetadataSize += len(name) - len(userMetadataPrefix) + len(value)
+		metadata[name] = value
+	}
+	if userMetadataSize > maxUserMetadataSize {
+		return nil, ErrMetadataTooLarge
+	}
+	return metadata, nil
+}
+
+func stripAWSChunkedEncoding(contentEncoding string) string {
+	encodings := []string{}
+	for _, encoding := range strings.Split(contentEncoding, ",") {
+		encoding = strings.TrimSpace(encoding)
+		if encoding != "" && encoding != "aws-chunked" {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return strings.Join(encodings, ", ")
+}
+
+// Sets the stored headers and user metadata of the object on the response
+func writeMetadataHeaders(w http.ResponseWriter, metadata map[string]string) {
+	for name, value := range metadata {
+		w.Header().Set(name, value)
+	}
+}
+
+// Metadata is kept in a single csv field in URL query form
+func encodeObjectMetadata(metadata map[string]string) string {
+	values := url.Values{}
+	for name, value := range metadata {
+		values.Set(name, value)
+	}
+	return values.Encode()
+}
+
+func decodeObjectMetadata(field string) (map[string]string, error) {
+	values, err := url.ParseQuery(field)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, len(values))
+	for name := range values {
+		metadata[http.CanonicalHeaderKey(name)] = values.Get(name)
+	}
+	return metadata, nil
+}
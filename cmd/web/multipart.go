@@ -0,0 +1,656 @@
+package web
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrNoSuchUpload      = errors.New("the specified multipart upload does not exist")
+	ErrInvalidPart       = errors.New("one or more of the specified parts could not be found or its ETag did not match")
+	ErrInvalidPartOrder  = errors.New("the list of parts was not in ascending order")
+	ErrEntityTooSmall    = errors.New("your proposed upload is smaller than the minimum allowed object size")
+	ErrInvalidPartNumber = errors.New("part number must be an integer between 1 and 10000")
+	ErrInvalidMaxParts   = errors.New("max-parts and max-uploads must be non-negative integers")
+	ErrMalformedXML      = errors.New("the XML you provided was not well-formed or did not validate against the published schema")
+)
+
+// Multipart upload limits
+const (
+	minPartSize   = 5 * bytesIn1mb
+	maxPartSize   = 5 * bytesIn1gb
+	maxPartNumber = 10000
+)
+
+type multipartUpload struct {
+	uploadID    string
+	bucketName  string
+	objectKey   string
+	initiated   string
+	contentType string
+	metadata    map[string]string
+	// Tags and canned ACL of the object given on creation
+	tags  map[string]string
+	acl   string
+	parts map[int]uploadPart
+}
+
+type uploadPart struct {
+	partNumber   int
+	size         int
+	etag         string
+	lastModified string
+}
+
+// Global registry of the multipart uploads in progress, key is the upload ID
+var (
+	multipartUploads   = map[string]*multipartUpload{}
+	multipartUploadsMu sync.Mutex
+)
+
+// Returns the registered upload of the object
+func findMultipartUpload(bucketName, objectName, uploadID string) (*multipartUpload, error) {
+	if _, exists := store.get(bucketName); !exists {
+		return nil, ErrBucketNotExists
+	}
+	multipartUploadsMu.Lock()
+	defer multipartUploadsMu.Unlock()
+	upload, exists := multipartUploads[uploadID]
+	if !exists || upload.bucketName != bucketName || upload.objectKey != objectName {
+		return nil, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+// Makes the upload available to the parts, completion and abort requests
+func registerMultipartUpload(upload *multipartUpload) {
+	multipartUploadsMu.Lock()
+	multipartUploads[upload.uploadID] = upload
+	multipartUploadsMu.Unlock()
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// POST ?uploads handler
+func createMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	// Names validation
+	for _, prohibitedName := range prohibitedObjectNames {
+		if prohibitedName == objectName {
+			return ErrProhibitedObjectName
+		}
+	}
+
+	metadata, err := objectMetadataFromRequest(r.Header)
+	if err != nil {
+		return err
+	}
+	tags, err := tagsFromRequest(r)
+	if err != nil {
+		return err
+	}
+	acl, err := cannedACLFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	idBytes := make([]byte, 24)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return fmt.Errorf("error while generating upload id: %w", err)
+	}
+	upload := &multipartUpload{
+		uploadID:    hex.EncodeToString(idBytes),
+		bucketName:  bucketName,
+		objectKey:   objectName,
+		initiated:   time.Now().UTC().Format(time.RFC3339),
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    metadata,
+		tags:        tags,
+		acl:         acl,
+		parts:       map[int]uploadPart{},
+	}
+
+	// Staged upload description, the bucket cannot be deleted until the upload is registered
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	err = backend.CreateUpload(upload)
+	if err != nil {
+		bucket.mu.RUnlock()
+		return fmt.Errorf("error while creating <%s> upload: %w", upload.uploadID, err)
+	}
+	registerMultipartUpload(upload)
+	bucket.mu.RUnlock()
+
+	marshalledObject, err := xml.MarshalIndent(initiateMultipartUploadResult{
+		Bucket:   bucketName,
+		Key:      objectName,
+		UploadID: upload.uploadID,
+	}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the <%s> upload: %w", upload.uploadID, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	log.Printf("<%s> multipart upload of <%s> object in <%s> bucket created", upload.uploadID, objectName, bucketName)
+	return nil
+}
+
+// PUT ?partNumber=&uploadId= handler, returns the ETag of the part
+func uploadObjectPart(r *http.Request, bucketName, objectName string) (string, error) {
+	query := r.URL.Query()
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return "", ErrInvalidPartNumber
+	}
+	upload, err := findMultipartUpload(bucketName, objectName, query.Get("uploadId"))
+	if err != nil {
+		return "", err
+	}
+
+	body, expectedLength, err := requestBody(r)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	if expectedLength > maxPartSize {
+		return "", ErrTooBigObject
+	}
+
+	// Part replaces the previous upload of the same part number only once it is fully received
+	partWriter, err := backend.CreatePart(upload, partNumber)
+	if err != nil {
+		return "", fmt.Errorf("error while creating part %d of <%s> upload: %w", partNumber, upload.uploadID, err)
+	}
+	defer partWriter.Abort()
+
+	written, etag, err := receiveBody(partWriter, body, expectedLength, maxPartSize)
+	if err != nil {
+		return "", err
+	}
+	err = partWriter.Close()
+	if err != nil {
+		return "", fmt.Errorf("error while writing part %d of <%s> upload: %w", partNumber, upload.uploadID, err)
+	}
+
+	part := uploadPart{
+		partNumber:   partNumber,
+		size:         int(written),
+		etag:         etag,
+		lastModified: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	multipartUploadsMu.Lock()
+	defer multipartUploadsMu.Unlock()
+	// The upload may be completed or aborted meanwhile
+	if _, exists := multipartUploads[upload.uploadID]; !exists {
+		return "", ErrNoSuchUpload
+	}
+	err = partWriter.Commit()
+	if err != nil {
+		return "", fmt.Errorf("error while moving part %d of <%s> upload: %w", partNumber, upload.uploadID, err)
+	}
+	err = backend.SavePart(upload, part)
+	if err != nil {
+		return "", fmt.Errorf("error while saving part %d of <%s> upload: %w", partNumber, upload.uploadID, err)
+	}
+	upload.parts[partNumber] = part
+
+	log.Printf("part %d of <%s> upload uploaded", partNumber, upload.uploadID)
+	return part.etag, nil
+}
+
+type completeMultipartUploadRequest struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// POST ?uploadId= handler
+func completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	upload, err := findMultipartUpload(bucketName, objectName, r.URL.Query().Get("uploadId"))
+	if err != nil {
+		return err
+	}
+
+	request := completeMultipartUploadRequest{}
+	err = xml.NewDecoder(io.LimitReader(r.Body, bytesIn1mb)).Decode(&request)
+	if err != nil || len(request.Parts) == 0 {
+		return ErrMalformedXML
+	}
+
+	// Take the upload out of the registry so that it cannot be completed or aborted twice
+	multipartUploadsMu.Lock()
+	if _, exists := multipartUploads[upload.uploadID]; !exists {
+		multipartUploadsMu.Unlock()
+		return ErrNoSuchUpload
+	}
+	delete(multipartUploads, upload.uploadID)
+	multipartUploadsMu.Unlock()
+
+	object, objectWriter, err := assembleParts(upload, request)
+	if err != nil {
+		// Upload stays available for retries
+		registerMultipartUpload(upload)
+		return err
+	}
+	defer objectWriter.Abort()
+
+	// Assembled object replaces the current one under the bucket lock,
+	// a deleted bucket took the upload with it
+	bucket, err := store.lockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.mu.Unlock()
+
+	err = commitObject(bucket, objectWriter, &object)
+	if err != nil {
+		registerMultipartUpload(upload)
+		return err
+	}
+	err = backend.RemoveUpload(upload)
+	if err != nil {
+		log.Printf("error while removing <%s> upload: %s", upload.uploadID, err)
+	}
+	if object.versionID != "" {
+		w.Header().Set("X-Amz-Version-Id", object.versionID)
+	}
+
+	marshalledObject, err := xml.MarshalIndent(completeMultipartUploadResult{
+		Location: "/" + bucketName + "/" + objectName,
+		Bucket:   bucketName,
+		Key:      objectName,
+		ETag:     quoteETag(object.etag),
+	}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the <%s> upload result: %w", upload.uploadID, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	log.Printf("<%s> multipart upload of <%s> object in <%s> bucket completed", upload.uploadID, objectName, bucketName)
+	return nil
+}
+
+// Concatenates the requested parts into a staged object, which is committed by the caller.
+// The ETag of the object is the MD5 of the concatenated binary part MD5s followed by the number of parts
+func assembleParts(upload *multipartUpload, request completeMultipartUploadRequest) (bucketObject, ObjectWriter, error) {
+	// Parts validation
+	for idx := 1; idx < len(request.Parts); idx++ {
+		if request.Parts[idx].PartNumber <= request.Parts[idx-1].PartNumber {
+			return bucketObject{}, nil, ErrInvalidPartOrder
+		}
+	}
+	for idx, requested := range request.Parts {
+		part, exists := upload.parts[requested.PartNumber]
+		if !exists || strings.Trim(requested.ETag, `"`) != part.etag {
+			return bucketObject{}, nil, ErrInvalidPart
+		}
+		if idx < len(request.Parts)-1 && part.size < minPartSize {
+			return bucketObject{}, nil, ErrEntityTooSmall
+		}
+	}
+
+	objectWriter, err := backend.CreateObject(upload.bucketName, upload.objectKey)
+	if err != nil {
+		return bucketObject{}, nil, fmt.Errorf("error while creating assembled object of <%s> upload: %w", upload.uploadID, err)
+	}
+
+	object, err := copyParts(objectWriter, upload, request)
+	if err == nil {
+		err = objectWriter.Close()
+	}
+	if err != nil {
+		objectWriter.Abort()
+		return bucketObject{}, nil, err
+	}
+	return object, objectWriter, nil
+}
+
+func copyParts(objectWriter ObjectWriter, upload *multipartUpload, request completeMultipartUploadRequest) (bucketObject, error) {
+	// Detect the MIME type unless the client specified it on creation,
+	// every part but the last one is larger than the signature
+	contentType := upload.contentType
+
+	etagHasher := md5.New()
+	size := 0
+	for _, requested := range request.Parts {
+		part := upload.parts[requested.PartNumber]
+		partReader, err := backend.OpenPart(upload, part.partNumber)
+		if err != nil {
+			return bucketObject{}, fmt.Errorf("error while opening part %d of <%s> upload: %w", part.partNumber, upload.uploadID, err)
+		}
+		if contentType == "" {
+			signatureBuf := make([]byte, 512)
+			n, err := partReader.ReadAt(signatureBuf, 0)
+			if err != nil && err != io.EOF {
+				partReader.Close()
+				return bucketObject{}, fmt.Errorf("error while reading first 512 bytes of <%s> upload: %w", upload.uploadID, err)
+			}
+			contentType = http.DetectContentType(signatureBuf[:n])
+		}
+		_, err = io.Copy(objectWriter, partReader)
+		partReader.Close()
+		if err != nil {
+			return bucketObject{}, fmt.Errorf("error while copying part %d of <%s> upload: %w", part.partNumber, upload.uploadID, err)
+		}
+		partMD5, err := hex.DecodeString(part.etag)
+		if err != nil {
+			return bucketObject{}, fmt.Errorf("error while decoding ETag of part %d of <%s> upload: %w", part.partNumber, upload.uploadID, err)
+		}
+		etagHasher.Write(partMD5)
+		size += part.size
+	}
+
+	return bucketObject{
+		objectKey:     upload.objectKey,
+		contentLength: size,
+		contentType:   contentType,
+		lastModified:  time.Now().UTC().Format(time.RFC3339),
+		etag:          fmt.Sprintf("%x-%d", etagHasher.Sum(nil), len(request.Parts)),
+		metadata:      upload.metadata,
+		tags:          upload.tags,
+		acl:           upload.acl,
+	}, nil
+}
+
+// DELETE ?uploadId= handler
+func abortMultipartUpload(bucketName, objectName, uploadID string) error {
+	upload, err := findMultipartUpload(bucketName, objectName, uploadID)
+	if err != nil {
+		return err
+	}
+	multipartUploadsMu.Lock()
+	defer multipartUploadsMu.Unlock()
+	return removeMultipartUpload(upload)
+}
+
+// Removes the upload from the registry and its staged parts, the caller must hold multipartUploadsMu
+func removeMultipartUpload(upload *multipartUpload) error {
+	if _, exists := multipartUploads[upload.uploadID]; !exists {
+		return ErrNoSuchUpload
+	}
+	delete(multipartUploads, upload.uploadID)
+	err := backend.RemoveUpload(upload)
+	if err != nil {
+		return fmt.Errorf("error while removing <%s> upload: %w", upload.uploadID, err)
+	}
+	log.Printf("<%s> multipart upload of <%s> object in <%s> bucket aborted", upload.uploadID, upload.objectKey, upload.bucketName)
+	return nil
+}
+
+type listPartsResult struct {
+	XMLName              xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListPartsResult"`
+	Bucket               string       `xml:"Bucket"`
+	Key                  string       `xml:"Key"`
+	UploadID             string       `xml:"UploadId"`
+	PartNumberMarker     int          `xml:"PartNumberMarker"`
+	NextPartNumberMarker int          `xml:"NextPartNumberMarker"`
+	MaxParts             int          `xml:"MaxParts"`
+	IsTruncated          bool         `xml:"IsTruncated"`
+	Parts                []listedPart `xml:"Part"`
+}
+
+type listedPart struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+// GET ?uploadId= handler
+func listParts(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	query := r.URL.Query()
+	upload, err := findMultipartUpload(bucketName, objectName, query.Get("uploadId"))
+	if err != nil {
+		return err
+	}
+
+	result := listPartsResult{
+		Bucket:   bucketName,
+		Key:      objectName,
+		UploadID: upload.uploadID,
+		MaxParts: maxListKeys,
+	}
+	if maxParts := query.Get("max-parts"); maxParts != "" {
+		n, err := strconv.Atoi(maxParts)
+		if err != nil || n < 0 {
+			return ErrInvalidMaxParts
+		}
+		if n < maxListKeys {
+			result.MaxParts = n
+		}
+	}
+	if marker := query.Get("part-number-marker"); marker != "" {
+		n, err := strconv.Atoi(marker)
+		if err != nil || n < 0 {
+			return ErrInvalidPartNumber
+		}
+		result.PartNumberMarker = n
+	}
+
+	multipartUploadsMu.Lock()
+	parts := make([]uploadPart, 0, len(upload.parts))
+	for _, part := range upload.parts {
+		parts = append(parts, part)
+	}
+	multipartUploadsMu.Unlock()
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].partNumber < parts[j].partNumber
+	})
+
+	for _, part := range parts {
+		if part.partNumber <= result.PartNumberMarker {
+			continue
+		}
+		if len(result.Parts) == result.MaxParts {
+			result.IsTruncated = true
+			break
+		}
+		result.Parts = append(result.Parts, listedPart{
+			PartNumber:   part.partNumber,
+			LastModified: formatListTime(part.lastModified),
+			ETag:         quoteETag(part.etag),
+			Size:         part.size,
+		})
+		result.NextPartNumberMarker = part.partNumber
+	}
+
+	marshalledObject, err := xml.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the parts of <%s> upload: %w", upload.uploadID, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+type listMultipartUploadsResult struct {
+	XMLName            xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListMultipartUploadsResult"`
+	Bucket             string         `xml:"Bucket"`
+	KeyMarker          string         `xml:"KeyMarker"`
+	UploadIDMarker     string         `xml:"UploadIdMarker"`
+	NextKeyMarker      string         `xml:"NextKeyMarker,omitempty"`
+	NextUploadIDMarker string         `xml:"NextUploadIdMarker,omitempty"`
+	Prefix             string         `xml:"Prefix"`
+	MaxUploads         int            `xml:"MaxUploads"`
+	IsTruncated        bool           `xml:"IsTruncated"`
+	Uploads            []listedUpload `xml:"Upload"`
+}
+
+type listedUpload struct {
+	Key          string `xml:"Key"`
+	UploadID     string `xml:"UploadId"`
+	Initiated    string `xml:"Initiated"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// GET /{bucket}?uploads handler
+func listMultipartUploads(w http.ResponseWriter, r *http.Request, bucketName string) error {
+	if _, exists := store.get(bucketName); !exists {
+		return ErrBucketNotExists
+	}
+	query := r.URL.Query()
+	result := listMultipartUploadsResult{
+		Bucket:         bucketName,
+		KeyMarker:      query.Get("key-marker"),
+		UploadIDMarker: query.Get("upload-id-marker"),
+		Prefix:         query.Get("prefix"),
+		MaxUploads:     maxListKeys,
+	}
+	if maxUploads := query.Get("max-uploads"); maxUploads != "" {
+		n, err := strconv.Atoi(maxUploads)
+		if err != nil || n < 0 {
+			return ErrInvalidMaxParts
+		}
+		if n < maxListKeys {
+			result.MaxUploads = n
+		}
+	}
+
+	multipartUploadsMu.Lock()
+	uploads := []multipartUpload{}
+	for _, upload := range multipartUploads {
+		if upload.bucketName == bucketName && strings.HasPrefix(upload.objectKey, result.Prefix) {
+			uploads = append(uploads, *upload)
+		}
+	}
+	multipartUploadsMu.Unlock()
+	// Uploads are ordered by key and then by initiation time
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].objectKey != uploads[j].objectKey {
+			return uploads[i].objectKey < uploads[j].objectKey
+		}
+		if uploads[i].initiated != uploads[j].initiated {
+			return uploads[i].initiated < uploads[j].initiated
+		}
+		return uploads[i].uploadID < uploads[j].uploadID
+	})
+
+	// Upload ID marker is only taken into account along with the key marker
+	skipping := result.KeyMarker != "" && result.UploadIDMarker != ""
+	for _, upload := range uploads {
+		if upload.objectKey < result.KeyMarker || (result.UploadIDMarker == "" && upload.objectKey == result.KeyMarker) {
+			continue
+		}
+		if skipping && upload.objectKey == result.KeyMarker {
+			if upload.uploadID == result.UploadIDMarker {
+				skipping = false
+			}
+			continue
+		}
+		if len(result.Uploads) == result.MaxUploads {
+			result.IsTruncated = true
+			break
+		}
+		result.Uploads = append(result.Uploads, listedUpload{
+			Key:          upload.objectKey,
+			UploadID:     upload.uploadID,
+			Initiated:    formatListTime(upload.initiated),
+			StorageClass: "STANDARD",
+		})
+		result.NextKeyMarker, result.NextUploadIDMarker = upload.objectKey, upload.uploadID
+	}
+	if !result.IsTruncated {
+		result.NextKeyMarker, result.NextUploadIDMarker = "", ""
+	}
+
+	marshalledObject, err := xml.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the multipart uploads of <%s> bucket: %w", bucketName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+// Loads the staged uploads of all buckets
+func loadMultipartUploads() error {
+	multipartUploadsMu.Lock()
+	defer multipartUploadsMu.Unlock()
+
+	for _, bucketName := range store.names() {
+		uploads, err := backend.LoadUploads(bucketName)
+		if err != nil {
+			return err
+		}
+		for _, upload := range uploads {
+			multipartUploads[upload.uploadID] = upload
+		}
+	}
+	log.Printf("loaded %d multipart uploads", len(multipartUploads))
+	return nil
+}
+
+// Aborts the multipart uploads initiated more than multipartUploadTTL ago, runs forever
+func sweepMultipartUploads() {
+	interval := multipartUploadTTL
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		multipartUploadsMu.Lock()
+		swept := 0
+		for _, upload := range multipartUploads {
+			initiated, err := parseObjectTime(upload.initiated)
+			if err != nil || time.Since(initiated) < multipartUploadTTL {
+				continue
+			}
+			err = removeMultipartUpload(upload)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			swept++
+		}
+		multipartUploadsMu.Unlock()
+		if swept > 0 {
+			log.Printf("swept %d abandoned multipart uploads", swept)
+		}
+	}
+}
+
+// Aborts all multipart uploads of the bucket, used when the bucket is deleted.
+// Uploads which failed to load are removed along with the bucket storage
+func abortBucketMultipartUploads(bucketName string) error {
+	multipartUploadsMu.Lock()
+	defer multipartUploadsMu.Unlock()
+	for _, upload := range multipartUploads {
+		if upload.bucketName != bucketName {
+			continue
+		}
+		err := removeMultipartUpload(upload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
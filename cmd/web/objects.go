@@ -1,206 +1,440 @@
 package web
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 )
 
 // Errors
 var (
-	ErrObjectNotExists        = errors.New("object does not exist")
-	ErrObjectAlreadyExists    = errors.New("object already exists")
-	ErrUndefinedContentLength = errors.New("object's size is undefined")
-	ErrTooBigObject           = errors.New("object's size is too big")
-	ErrProhibitedObjectName   = errors.New("object's name is prohibited")
+	ErrObjectNotExists      = errors.New("object does not exist")
+	ErrObjectAlreadyExists  = errors.New("object already exists")
+	ErrTooBigObject         = errors.New("object's size is too big")
+	ErrProhibitedObjectName = errors.New("object's name is prohibited")
 )
 
-// Number of bytes in 1gb
-const bytesIn1gb = 1024 * 1024 * 1024
+// Number of bytes in 1mb and 1gb
+const (
+	bytesIn1mb = 1024 * 1024
+	bytesIn1gb = 1024 * bytesIn1mb
+)
 
 type bucketObject struct {
 	objectKey     string
 	contentLength int
 	contentType   string
 	lastModified  string
+	// Hex encoded MD5 of the content, empty for objects uploaded before ETags were introduced
+	etag string
+	// Stored headers and user metadata replayed on GET and HEAD, keys are canonical header names
+	metadata map[string]string
+	// Canned ACL given on upload, empty when reads follow the ACL of the bucket
+	acl string
+	// Version of the object, empty for the null version written while versioning was not enabled
+	versionID string
+	// Set for the delete markers among the noncurrent versions, they have no content
+	deleteMarker bool
+	// Tags of the object, nil when it has none
+	tags map[string]string
 }
 
-func retrieveObject(w http.ResponseWriter, bucketName, objectName string) error {
-	// Bucket existence check
-	if _, exists := bucketMap[bucketName]; !exists {
-		return ErrBucketNotExists
-	}
-
-	// Object not existence check in the bucket
-	for _, object := range *bucketMap[bucketName].objects {
-		if object.objectKey == objectName {
-			objectPath := filepath.Join(storagePath, bucketName, objectName)
-			objectFile, err := os.Open(objectPath)
-			if err != nil {
-				return fmt.Errorf("error while opening <%s> object in <%s> bucket: %w", objectName, bucketName, err)
-			}
-
-			// Set length of response
-			fileInfo, err := objectFile.Stat()
-			if err != nil {
-				return fmt.Errorf("error while getting file info of <%s> object in <%s> bucket: %w", objectName, bucketName, err)
-			}
-			w.Header().Set("Content-Length", strconv.Itoa(int(fileInfo.Size())))
-
-			// Set MimeType of response
-			signatureBuf := make([]byte, 512)
-			n, err := objectFile.Read(signatureBuf)
-			if err != nil && err != io.EOF {
-				return fmt.Errorf("error while reading first 512 bytes from <%s> object: %w", objectName, err)
-			}
-			w.Header().Set("Content-Type", http.DetectContentType(signatureBuf[:n]))
-			objectFile.Seek(0, 0)
-
-			signatureBuf = nil
-			buf := make([]byte, 100)
-			for {
-				n, err := objectFile.Read(buf)
-				if err != nil && err != io.EOF {
-					return fmt.Errorf("error while reading <%s> object in <%s> bucket: %w", objectName, bucketName, err)
-				}
-				if n == 0 {
-					break
-				}
-				w.Write(buf[:n])
-			}
-			buf = nil
-			return nil
+func retrieveObject(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	object, objectFile, err := openObject(bucketName, objectName, r.URL.Query().Get("versionId"))
+	if err != nil {
+		return err
+	}
+	defer objectFile.Close()
+
+	// Conditional request
+	err = checkPreconditions(r, object)
+	if err != nil {
+		if err == ErrNotModified {
+			writeValidatorHeaders(w, object)
+		}
+		return err
+	}
+
+	size := objectFile.Size()
+
+	// Partial content
+	ranges, err := parseRange(r.Header.Get("Range"), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return err
+	}
+
+	writeObjectHeaders(w, object)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+
+	// Set MimeType of response, it is detected only when neither the client nor the upload provided it
+	contentType := object.contentType
+	if contentType == "" {
+		signatureBuf := make([]byte, 512)
+		n, err := objectFile.ReadAt(signatureBuf, 0)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error while reading first 512 bytes from <%s> object: %w", objectName, err)
+		}
+		contentType = http.DetectContentType(signatureBuf[:n])
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	if len(ranges) > 0 {
+		err = writeRanges(w, objectFile, size, contentType, ranges)
+		if err != nil {
+			log.Printf("error while sending ranges of <%s> object in <%s> bucket: %s", objectName, bucketName, err)
 		}
+		return nil
+	}
+
+	_, err = io.Copy(w, objectFile)
+	if err != nil {
+		log.Printf("error while sending <%s> object in <%s> bucket: %s", objectName, bucketName, err)
+	}
+	return nil
+}
+
+// HEAD handler
+func headObject(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	object, err := lookupObject(bucketName, objectName, r.URL.Query().Get("versionId"))
+	if err != nil {
+		return err
+	}
+
+	// Conditional request
+	err = checkPreconditions(r, object)
+	if err != nil {
+		if err == ErrNotModified {
+			writeValidatorHeaders(w, object)
+		}
+		return err
+	}
+	writeObjectHeaders(w, object)
+	log.Printf("<%s> object metadata in <%s> bucket requested", objectName, bucketName)
+	return nil
+}
+
+// Returns the metadata record of the object along with its opened content, the current version
+// unless a version ID is given. Contents are replaced on commit, so the opened one keeps matching
+// the record after the bucket lock is released
+func openObject(bucketName, objectName, versionID string) (bucketObject, ObjectReader, error) {
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		return bucketObject{}, nil, err
+	}
+	defer bucket.mu.RUnlock()
+
+	object, current, err := findObjectVersion(bucket, objectName, versionID)
+	if err != nil {
+		return bucketObject{}, nil, err
+	}
+	var objectFile ObjectReader
+	if current {
+		objectFile, err = backend.OpenObject(bucketName, objectName)
+	} else {
+		objectFile, err = backend.OpenVersion(bucketName, objectName, object.versionID)
+	}
+	if err != nil {
+		return bucketObject{}, nil, fmt.Errorf("error while opening <%s> object in <%s> bucket: %w", objectName, bucketName, err)
+	}
+	return object, objectFile, nil
+}
+
+// Returns the metadata record of the object, the current version unless a version ID is given
+func lookupObject(bucketName, objectName, versionID string) (bucketObject, error) {
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		return bucketObject{}, err
+	}
+	defer bucket.mu.RUnlock()
+	object, _, err := findObjectVersion(bucket, objectName, versionID)
+	return object, err
+}
+
+// Returns the metadata record of the object, the caller must hold the bucket lock
+func findObject(bucket *bucketData, objectName string) (bucketObject, error) {
+	object, exists := bucket.objects.find(objectName)
+	if !exists {
+		return bucketObject{}, ErrObjectNotExists
+	}
+	return object, nil
+}
+
+// Sets the response headers describing the object from its metadata record
+func writeObjectHeaders(w http.ResponseWriter, object bucketObject) {
+	w.Header().Set("Content-Length", strconv.Itoa(object.contentLength))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if object.contentType != "" {
+		w.Header().Set("Content-Type", object.contentType)
+	}
+	if object.versionID != "" {
+		w.Header().Set("X-Amz-Version-Id", object.versionID)
+	}
+	if len(object.tags) > 0 {
+		w.Header().Set("X-Amz-Tagging-Count", strconv.Itoa(len(object.tags)))
+	}
+	writeMetadataHeaders(w, object.metadata)
+	writeValidatorHeaders(w, object)
+}
+
+// Sets the validator headers sent along with 304 Not Modified
+func writeValidatorHeaders(w http.ResponseWriter, object bucketObject) {
+	if lastModified, err := parseObjectTime(object.lastModified); err == nil {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if object.etag != "" {
+		w.Header().Set("ETag", quoteETag(object.etag))
 	}
-	return ErrObjectNotExists
 }
 
 var prohibitedObjectNames = []string{
 	"objects.csv",
+	"objects.log",
 }
 
-func uploadObject(r *http.Request, bucketName, objectName string) error {
+// PUT handler, returns the record of the uploaded object
+func uploadObject(r *http.Request, bucketName, objectName string) (bucketObject, error) {
 	// Names validation
 	for _, prohibitedName := range prohibitedObjectNames {
 		if prohibitedName == objectName {
-			return ErrProhibitedObjectName
+			return bucketObject{}, ErrProhibitedObjectName
 		}
 	}
 
 	// Bucket existence check
-	if _, exists := bucketMap[bucketName]; !exists {
-		return ErrBucketNotExists
-	}
-
-	// Object existence check in the bucket
-	for _, object := range *bucketMap[bucketName].objects {
-		if object.objectKey == objectName {
-			err := deleteObject(bucketName, object.objectKey)
-			if err != nil {
-				return fmt.Errorf("error while deleting existing object: %w", err)
-			}
-			break
-		}
+	if _, exists := store.get(bucketName); !exists {
+		return bucketObject{}, ErrBucketNotExists
 	}
 
-	// Object upload
+	// Conditional write is checked before receiving the body and again before replacing the object
+	err := checkObjectWritePreconditions(r, bucketName, objectName)
+	if err != nil {
+		return bucketObject{}, err
+	}
 
-	// length validation
-	contentLength := r.ContentLength
-	if contentLength == -1 {
-		return ErrUndefinedContentLength
-		// 1GB restriction
-	} else if contentLength > bytesIn1gb {
-		return ErrTooBigObject
+	// Headers stored along with the object
+	metadata, err := objectMetadataFromRequest(r.Header)
+	if err != nil {
+		return bucketObject{}, err
+	}
+	acl, err := cannedACLFromRequest(r)
+	if err != nil {
+		return bucketObject{}, err
 	}
-	signatureBuf := make([]byte, 512)
-	n, err := r.Body.Read(signatureBuf)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("error while reading request body in <%s> object and <%s> bucket: %w", objectName, bucketName, err)
+	tags, err := tagsFromRequest(r)
+	if err != nil {
+		return bucketObject{}, err
+	}
+
+	// Object upload
+	body, expectedLength, err := requestBody(r)
+	if err != nil {
+		return bucketObject{}, err
 	}
 	defer r.Body.Close()
+	// 1GB restriction on the declared length, the received bytes are checked while writing
+	if expectedLength > bytesIn1gb {
+		return bucketObject{}, ErrTooBigObject
+	}
 
-	// Detect the MIME type
-	contentType := http.DetectContentType(signatureBuf)
-
-	// Create the file in storage and upload request body into it
-	objectPath := filepath.Join(storagePath, bucketName, objectName)
-	objectFile, err := os.OpenFile(objectPath, os.O_WRONLY|os.O_CREATE, 0o644)
-	if err != nil {
-		return fmt.Errorf("error while creating <%s> object in <%s> bucket: %w", objectName, bucketName, err)
-	} else if contentLength > 0 {
-		// Write the file
-		objectFile.Write(signatureBuf[:n])
-		signatureBuf = nil
-		buf := make([]byte, 100)
-		for {
-			n, err := r.Body.Read(buf)
-			if err != nil && err != io.EOF {
-				return fmt.Errorf("error while reading request body in <%s> object and <%s> bucket: %w", objectName, bucketName, err)
-			} else if n == 0 {
-				break
-			}
-			objectFile.Write(buf[:n])
+	// Detect the MIME type unless the client specified it
+	bufferedBody := bufio.NewReaderSize(body, 512)
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		// Read errors are returned again while receiving the body
+		signatureBuf, _ := bufferedBody.Peek(512)
+		contentType = http.DetectContentType(signatureBuf)
+	}
 
-		}
-		buf = nil
+	// Request body is staged without holding the bucket lock,
+	// so a failed or interrupted upload never touches the current object
+	objectWriter, err := backend.CreateObject(bucketName, objectName)
+	if err != nil {
+		return bucketObject{}, fmt.Errorf("error while creating <%s> object in <%s> bucket: %w", objectName, bucketName, err)
 	}
-	defer objectFile.Close()
+	defer objectWriter.Abort()
 
-	// Read the request body sequentially
+	contentLength, etag, err := receiveBody(objectWriter, bufferedBody, expectedLength, bytesIn1gb)
+	if err != nil {
+		return bucketObject{}, err
+	}
+	err = objectWriter.Close()
+	if err != nil {
+		return bucketObject{}, fmt.Errorf("error while writing <%s> object in <%s> bucket: %w", objectName, bucketName, err)
+	}
+
+	// Uploaded object replaces the current one under the bucket lock
+	bucket, err := store.lockBucket(bucketName)
+	if err != nil {
+		return bucketObject{}, err
+	}
+	defer bucket.mu.Unlock()
+
+	err = checkWritePreconditions(r, bucket, objectName)
+	if err != nil {
+		return bucketObject{}, err
+	}
 
-	// Append metadata
-	*bucketMap[bucketName].objects = append(*bucketMap[bucketName].objects, bucketObject{
+	object := bucketObject{
 		objectKey:     objectName,
 		contentLength: int(contentLength),
 		contentType:   contentType,
-		lastModified:  time.Now().Format(time.RFC822),
-	})
+		lastModified:  time.Now().UTC().Format(time.RFC3339),
+		etag:          etag,
+		metadata:      metadata,
+		acl:           acl,
+		tags:          tags,
+	}
+	err = commitObject(bucket, objectWriter, &object)
+	if err != nil {
+		return bucketObject{}, err
+	}
+	return object, nil
+}
 
-	err = saveObjectsData(bucketName, objectName)
+// Replaces the object content with the committed writer and persists its record through the journal,
+// the caller must hold the bucket lock for writing. The object gets its version ID and the replaced
+// object is kept as a noncurrent version when the bucket is versioned
+func commitObject(bucket *bucketData, objectWriter ObjectWriter, object *bucketObject) error {
+	versioning := bucketVersioning(bucket)
+	object.versionID = ""
+	if versioning == versioningEnabled {
+		object.versionID = newVersionID()
+	}
+	entries := replaceCurrentEntries(bucket, versioning, object.objectKey)
+	transactionID, err := journal.begin(append(entries, putObjectEntry(bucket.Name, *object))...)
 	if err != nil {
-		return fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucketName, err)
+		return err
 	}
+	defer journal.abort(transactionID)
 
+	// The replaced object is archived before its content is overwritten and put back if the put fails,
+	// the replaced null version is removed only once the object took its place
+	archives, removals := []journalEntry{}, []journalEntry{}
+	for _, entry := range entries {
+		if entry.operation == journalArchiveObject {
+			archives = append(archives, entry)
+		} else {
+			removals = append(removals, entry)
+		}
+	}
+	err = applyVersionEntries(bucket, archives)
+	if err == nil {
+		err = objectWriter.Commit()
+		if err != nil {
+			err = fmt.Errorf("error while moving <%s> object in <%s> bucket: %w", object.objectKey, bucket.Name, err)
+		}
+	}
+	if err == nil {
+		err = setObjectRecord(bucket, *object)
+		if err != nil {
+			err = fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucket.Name, err)
+		}
+	}
+	if err != nil {
+		restoreArchivedObjects(bucket, archives)
+		return err
+	}
+	err = applyVersionEntries(bucket, removals)
+	if err != nil {
+		return err
+	}
+	journal.end(transactionID)
 	return nil
 }
 
-func deleteObject(bucketName, objectName string) error {
-	// Bucket existence check
-	if _, exists := bucketMap[bucketName]; !exists {
-		return ErrBucketNotExists
+// Puts the objects archived by the entries back in place after the put replacing them failed,
+// the caller must hold the bucket lock for writing
+func restoreArchivedObjects(bucket *bucketData, archives []journalEntry) {
+	restores := make([]journalEntry, 0, len(archives))
+	for _, entry := range archives {
+		restores = append(restores, versionEntry(journalRestoreObject, bucket.Name, entry.object))
+	}
+	err := applyVersionEntries(bucket, restores)
+	if err != nil {
+		log.Printf("error while restoring the replaced objects in <%s> bucket: %s", bucket.Name, err)
+	}
+}
+
+// Replaces the metadata record of the object or inserts it if the object is new and persists it,
+// the caller must hold the bucket lock for writing
+func setObjectRecord(bucket *bucketData, object bucketObject) error {
+	bucket.objects.set(object)
+	return saveObjectsData(bucket, objectChange{object: object})
+}
+
+// DELETE handler, a versioned bucket gets a delete marker and a given version is removed for good
+func deleteObject(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	bucket, err := store.lockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.mu.Unlock()
+
+	versioning, versionID := bucketVersioning(bucket), r.URL.Query().Get("versionId")
+	var version bucketObject
+	if versionID != "" {
+		version, err = deleteVersion(bucket, versioning, objectName, versionID)
+	} else {
+		version, err = deleteCurrentObject(bucket, versioning, objectName)
+	}
+	if err != nil {
+		return err
+	}
+	writeVersionHeaders(w, versioning, version)
+	log.Printf("<%s> object in <%s> bucket deleted", objectName, bucketName)
+	return nil
+}
+
+// Deletes the current object of the key, a versioned bucket keeps it behind a delete marker.
+// The caller must hold the bucket lock for writing. Returns the placed delete marker
+func deleteCurrentObject(bucket *bucketData, versioning, objectName string) (bucketObject, error) {
+	if versioning != "" {
+		return deleteVersion(bucket, versioning, objectName, "")
 	}
 
 	// Object existence check
-	for idx, object := range *bucketMap[bucketName].objects {
-		if object.objectKey == objectName {
-			// Remove from objects slice
-			*bucketMap[bucketName].objects = append((*bucketMap[bucketName].objects)[:idx], (*bucketMap[bucketName].objects)[idx+1:]...)
-
-			// Remove object from bucket in disk
-			objectPath := filepath.Join(storagePath, bucketName, objectName)
-			err := os.Remove(objectPath)
-			if err != nil {
-				if os.IsNotExist(err) {
-					err = nil
-				} else {
-					return fmt.Errorf("error while removing <%s> object in <%s> bucket: %w", objectName, bucketName, err)
-				}
-			}
-
-			// Update metadata in objects.csv file
-			err = saveObjectsData(bucketName, objectName)
-			if err != nil {
-				return fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucketName, err)
-			}
-
-			return nil
-		}
+	if _, exists := bucket.objects.find(objectName); !exists {
+		return bucketObject{}, ErrObjectNotExists
 	}
-	return ErrObjectNotExists
+
+	transactionID, err := journal.begin(deleteObjectEntry(bucket.Name, objectName))
+	if err != nil {
+		return bucketObject{}, err
+	}
+	defer journal.abort(transactionID)
+	err = removeObject(bucket, objectName)
+	if err != nil {
+		return bucketObject{}, err
+	}
+
+	// Update objects metadata
+	err = saveObjectsData(bucket, removedObjectChange(objectName))
+	if err != nil {
+		return bucketObject{}, fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucket.Name, err)
+	}
+	journal.end(transactionID)
+	return bucketObject{objectKey: objectName}, nil
+}
+
+// Removes the object content and its record, the caller must hold the bucket lock
+// for writing and persists the removal
+func removeObject(bucket *bucketData, objectName string) error {
+	// Object existence check
+	if _, exists := bucket.objects.find(objectName); !exists {
+		return ErrObjectNotExists
+	}
+
+	// Remove object content from the bucket, the record is kept if it fails
+	err := backend.RemoveObject(bucket.Name, objectName)
+	if err != nil {
+		return fmt.Errorf("error while removing <%s> object in <%s> bucket: %w", objectName, bucket.Name, err)
+	}
+	bucket.objects.remove(objectName)
+	return nil
 }
@@ -0,0 +1,317 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Errors
+var (
+	ErrMalformedPolicy = errors.New("the policy document is malformed")
+)
+
+// Policy statement effects
+const (
+	policyAllow = "Allow"
+	policyDeny  = "Deny"
+)
+
+// Condition keys filled in from the request
+const (
+	conditionSourceIP = "aws:SourceIp"
+	conditionPrefix   = "s3:prefix"
+	// Followed by the tag key
+	conditionExistingObjectTag = "s3:ExistingObjectTag/"
+	conditionRequestObjectTag  = "s3:RequestObjectTag/"
+)
+
+// policyDocument is an IAM-style policy:
+//
+//	{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:GetObject",
+//	  "Resource": "arn:aws:s3:::bucket/*", "Condition": {"IpAddress": {"aws:SourceIp": "10.0.0.0/8"}}}]}
+//
+// Actions and resources may contain * and ? wildcards
+type policyDocument struct {
+	Version   string            `json:"Version,omitempty"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Sid    string `json:"Sid,omitempty"`
+	Effect string `json:"Effect"`
+	// Callers the statement applies to, set in bucket policies only
+	Principal *policyPrincipal `json:"Principal,omitempty"`
+	Action    stringOrList     `json:"Action"`
+	Resource  stringOrList     `json:"Resource"`
+	// Operator, then condition key, then the accepted values
+	Condition map[string]map[string]stringOrList `json:"Condition,omitempty"`
+}
+
+// policyPrincipal is either "*" or {"AWS": ["arn:aws:iam:::user/<user>", ...]}, "*" includes anonymous callers
+type policyPrincipal struct {
+	AWS stringOrList `json:"AWS"`
+}
+
+func (p *policyPrincipal) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		if single != "*" {
+			return ErrMalformedPolicy
+		}
+		p.AWS = stringOrList{"*"}
+		return nil
+	}
+	var principal struct {
+		AWS stringOrList `json:"AWS"`
+	}
+	err := json.Unmarshal(data, &principal)
+	if err != nil {
+		return err
+	}
+	p.AWS = principal.AWS
+	return nil
+}
+
+// Reports whether the statement applies to the caller
+func (p *policyPrincipal) matches(principal string) bool {
+	if p == nil {
+		return true
+	}
+	for _, candidate := range p.AWS {
+		if candidate == "*" || candidate == principal {
+			return true
+		}
+	}
+	return false
+}
+
+// stringOrList is a JSON string or a list of strings
+type stringOrList []string
+
+func (s *stringOrList) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*s = stringOrList{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// Parses and validates the policy document
+func parsePolicy(data []byte) (*policyDocument, error) {
+	policy := &policyDocument{}
+	err := json.Unmarshal(data, policy)
+	if err != nil || len(policy.Statement) == 0 {
+		return nil, ErrMalformedPolicy
+	}
+	for _, statement := range policy.Statement {
+		if statement.Effect != policyAllow && statement.Effect != policyDeny {
+			return nil, ErrMalformedPolicy
+		} else if len(statement.Action) == 0 || len(statement.Resource) == 0 {
+			return nil, ErrMalformedPolicy
+		}
+		for operator, conditions := range statement.Condition {
+			for key, values := range conditions {
+				if !validCondition(operator, key, values) {
+					return nil, ErrMalformedPolicy
+				}
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Parses the policy attached to a user, it applies to the user so it names no principal
+func parseUserPolicy(data []byte) (*policyDocument, error) {
+	policy, err := parsePolicy(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, statement := range policy.Statement {
+		if statement.Principal != nil {
+			return nil, ErrMalformedPolicy
+		}
+	}
+	return policy, nil
+}
+
+// Parses the policy attached to a bucket, every statement names its principal
+// and its resources are the bucket or the objects in it
+func parseBucketPolicy(data []byte, bucketName string) (*policyDocument, error) {
+	policy, err := parsePolicy(data)
+	if err != nil {
+		return nil, err
+	}
+	bucketARN := resourceARN(bucketName, "")
+	for _, statement := range policy.Statement {
+		if statement.Principal == nil || len(statement.Principal.AWS) == 0 {
+			return nil, ErrMalformedPolicy
+		}
+		for _, resource := range statement.Resource {
+			if resource != bucketARN && !strings.HasPrefix(resource, bucketARN+"/") {
+				return nil, ErrMalformedPolicy
+			}
+		}
+	}
+	return policy, nil
+}
+
+// ARN of the user as named in the policy principals
+func userARN(userName string) string {
+	return "arn:aws:iam:::user/" + userName
+}
+
+// Only the source IP, the listed prefix and the object tag conditions are supported
+func validCondition(operator, key string, values stringOrList) bool {
+	switch operator {
+	case "IpAddress", "NotIpAddress":
+		if key != conditionSourceIP {
+			return false
+		}
+		for _, value := range values {
+			if _, _, err := net.ParseCIDR(value); err != nil && net.ParseIP(value) == nil {
+				return false
+			}
+		}
+		return true
+	case "StringEquals", "StringNotEquals", "StringLike", "StringNotLike":
+		return key == conditionPrefix || strings.HasPrefix(key, conditionExistingObjectTag) || strings.HasPrefix(key, conditionRequestObjectTag)
+	default:
+		return false
+	}
+}
+
+// Result of the policy evaluation
+type policyDecision int
+
+const (
+	// No statement matched the request
+	policyNoMatch policyDecision = iota
+	policyAllowed
+	policyDenied
+)
+
+// Evaluates the policy for the caller, an explicit deny wins over any allow.
+// Principal is the ARN of the caller, empty for anonymous callers
+func (policy *policyDocument) evaluate(principal, action, resource string, context map[string]string) policyDecision {
+	decision := policyNoMatch
+	for _, statement := range policy.Statement {
+		if !statement.Principal.matches(principal) {
+			continue
+		}
+		if !matchesAny(statement.Action, action, true) || !matchesAny(statement.Resource, resource, false) {
+			continue
+		}
+		if !statement.conditionsHold(context) {
+			continue
+		}
+		if statement.Effect == policyDeny {
+			return policyDenied
+		}
+		decision = policyAllowed
+	}
+	return decision
+}
+
+// Reports whether all conditions of the statement hold for the request
+func (statement policyStatement) conditionsHold(context map[string]string) bool {
+	for operator, conditions := range statement.Condition {
+		for key, values := range conditions {
+			value, exists := context[key]
+			var holds bool
+			switch operator {
+			case "IpAddress":
+				holds = exists && ipInAny(values, value)
+			case "NotIpAddress":
+				holds = exists && !ipInAny(values, value)
+			case "StringEquals":
+				holds = exists && containsString(values, value)
+			case "StringNotEquals":
+				holds = !exists || !containsString(values, value)
+			case "StringLike":
+				holds = exists && matchesAny(values, value, false)
+			case "StringNotLike":
+				holds = !exists || !matchesAny(values, value, false)
+			}
+			if !holds {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Reports whether the value matches any of the wildcard patterns
+func matchesAny(patterns []string, value string, ignoreCase bool) bool {
+	for _, pattern := range patterns {
+		if ignoreCase {
+			pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+		}
+		if wildcardMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches the value against the pattern where * matches any sequence, slashes included, and ? any byte
+func wildcardMatch(pattern, value string) bool {
+	patternIdx, valueIdx := 0, 0
+	// Position of the last star and the value position it was tried at
+	starIdx, starValueIdx := -1, 0
+	for valueIdx < len(value) {
+		switch {
+		case patternIdx < len(pattern) && (pattern[patternIdx] == '?' || pattern[patternIdx] == value[valueIdx]):
+			patternIdx++
+			valueIdx++
+		case patternIdx < len(pattern) && pattern[patternIdx] == '*':
+			starIdx, starValueIdx = patternIdx, valueIdx
+			patternIdx++
+		case starIdx >= 0:
+			// Star takes one more byte
+			starValueIdx++
+			patternIdx, valueIdx = starIdx+1, starValueIdx
+		default:
+			return false
+		}
+	}
+	for patternIdx < len(pattern) && pattern[patternIdx] == '*' {
+		patternIdx++
+	}
+	return patternIdx == len(pattern)
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the IP address is in any of the CIDR blocks or equals any of the addresses
+func ipInAny(blocks []string, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, block := range blocks {
+		if _, network, err := net.ParseCIDR(block); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if blockIP := net.ParseIP(block); blockIP != nil && blockIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package web
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrPresignedSignatureMismatch = errors.New("the presigned URL signature does not match, the URL was altered")
+	ErrAuthenticationDisabled     = errors.New("no credentials are configured, requests are not signed")
+	ErrInvalidPresignMethod       = errors.New("only GET and PUT URLs can be presigned")
+	ErrInvalidPresignExpires      = errors.New("expiry of the presigned URL must be between 1 second and 7 days")
+)
+
+// Region put into the scope of the generated signatures, any region is accepted on verification
+const presignRegion = "us-east-1"
+
+// Default lifetime of a presigned URL
+const defaultPresignExpires = time.Hour
+
+// Admin routes live under a prefix which is never a valid bucket name
+const adminPathPrefix = "/_admin/"
+
+type presignResult struct {
+	XMLName xml.Name `xml:"PresignResult"`
+	URL     string   `xml:"URL"`
+	Method  string   `xml:"Method"`
+	Expires string   `xml:"Expires"`
+}
+
+// Builds the presigned URL of the object under the endpoint, the URL is signed with the host header only
+// and the payload of a presigned PUT is unsigned
+func presignURL(endpoint *url.URL, method, bucketName, objectKey, accessKeyID, secretKey string, expires time.Duration, now time.Time) (string, error) {
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", ErrInvalidPresignMethod
+	}
+	if expires < time.Second || expires > maxPresignedExpires*time.Second {
+		return "", ErrInvalidPresignExpires
+	}
+
+	sig := signature{
+		accessKeyID:   accessKeyID,
+		date:          now.UTC().Truncate(time.Second),
+		region:        presignRegion,
+		signedHeaders: []string{"host"},
+		payloadHash:   unsignedPayload,
+		expires:       int(expires / time.Second),
+	}
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", signatureAlgorithm)
+	query.Set("X-Amz-Credential", accessKeyID+"/"+sig.scope())
+	query.Set("X-Amz-Date", sig.date.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(sig.expires))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	presigned := &url.URL{
+		Scheme:   endpoint.Scheme,
+		Host:     endpoint.Host,
+		Path:     "/" + bucketName + "/" + objectKey,
+		RawPath:  "/" + bucketName + "/" + uriEncode(objectKey, false),
+		RawQuery: query.Encode(),
+	}
+	// Canonical request is built the same way it is verified
+	r, err := http.NewRequest(method, presigned.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("error while building presigned URL of <%s> object: %w", objectKey, err)
+	}
+	signingKey := deriveSigningKey(secretKey, sig.date, sig.region)
+	stringToSign := strings.Join([]string{signatureAlgorithm, sig.date.Format(amzDateFormat), sig.scope(), hashHex([]byte(buildCanonicalRequest(r, sig)))}, "\n")
+	query.Set("X-Amz-Signature", hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign))))
+	presigned.RawQuery = query.Encode()
+	return presigned.String(), nil
+}
+
+// GET /_admin/presign?method=<GET|PUT>&bucket=<bucket>&key=<key>&expires=<duration> handler.
+// The URL is signed with the access key of the caller, so it never grants more than the caller has
+func presignObject(w http.ResponseWriter, r *http.Request) error {
+	identity := identityFromRequest(r)
+	if credentials == nil {
+		return ErrAuthenticationDisabled
+	} else if identity == nil {
+		return ErrAccessDenied
+	}
+
+	query := r.URL.Query()
+	bucketName, objectKey := query.Get("bucket"), query.Get("key")
+	err := validateBucketName(bucketName)
+	if err != nil {
+		return err
+	}
+	err = validateObjectKey(objectKey)
+	if err != nil {
+		return err
+	}
+	method := strings.ToUpper(query.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	expires := defaultPresignExpires
+	if value := query.Get("expires"); value != "" {
+		expires, err = time.ParseDuration(value)
+		if err != nil {
+			return ErrInvalidPresignExpires
+		}
+	}
+
+	endpoint := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		endpoint.Scheme = "https"
+	}
+	secretKey, _, exists := lookupAccessKey(identity.accessKeyID)
+	if !exists {
+		return ErrInvalidAccessKeyID
+	}
+	now := time.Now()
+	presigned, err := presignURL(endpoint, method, bucketName, objectKey, identity.accessKeyID, secretKey, expires, now)
+	if err != nil {
+		return err
+	}
+
+	marshalledObject, err := xml.MarshalIndent(presignResult{
+		URL:     presigned,
+		Method:  method,
+		Expires: now.Add(expires).UTC().Format(time.RFC3339),
+	}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the presigned URL of <%s> object: %w", objectKey, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+// Presign prints a presigned URL of the object:
+//
+//	triple-s presign --credentials <S> --access-key <K> [--endpoint <URL>] [--method <M>] [--expires <D>] <bucket>/<key>
+func Presign(args []string) error {
+	endpoint := fmt.Sprintf("http://localhost:%d", Port)
+	method, accessKeyID, objectPath := http.MethodGet, "", ""
+	expires := defaultPresignExpires
+	for argIdx := 0; argIdx < len(args); argIdx++ {
+		flagName := strings.TrimPrefix(args[argIdx], "--")
+		if flagName == "help" {
+			PrintHelp()
+			return ErrHelpCalled
+		} else if flagName == args[argIdx] {
+			objectPath = args[argIdx]
+			continue
+		}
+		if argIdx+1 == len(args) {
+			return ErrInvalidNumberOfArguments
+		}
+		argIdx++
+		flagValue := args[argIdx]
+		switch flagName {
+		case "credentials":
+			credentialsPath = flagValue
+		case "access-key":
+			accessKeyID = flagValue
+		case "endpoint":
+			endpoint = flagValue
+		case "method":
+			method = strings.ToUpper(flagValue)
+		case "expires":
+			var err error
+			expires, err = time.ParseDuration(flagValue)
+			if err != nil {
+				return fmt.Errorf("error while parsing the expiry: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown presign argument: %s", args[argIdx-1])
+		}
+	}
+
+	bucketName, objectKey, found := strings.Cut(objectPath, "/")
+	if !found {
+		return fmt.Errorf("object must be given as <bucket>/<key>")
+	}
+	err := validateBucketName(bucketName)
+	if err == nil {
+		err = validateObjectKey(objectKey)
+	}
+	if err != nil {
+		return err
+	}
+	if credentialsPath == "" {
+		return ErrAuthenticationDisabled
+	}
+	loaded, err := loadCredentials(credentialsPath)
+	if err != nil {
+		return err
+	}
+	secretKey, exists := loaded[accessKeyID]
+	if !exists {
+		return ErrInvalidAccessKeyID
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return fmt.Errorf("endpoint must be an absolute URL: %s", endpoint)
+	}
+
+	presigned, err := presignURL(endpointURL, method, bucketName, objectKey, accessKeyID, secretKey, expires, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Println(presigned)
+	return nil
+}
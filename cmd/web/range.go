@@ -0,0 +1,145 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// Errors
+var (
+	ErrInvalidRange = errors.New("the requested range is not satisfiable")
+)
+
+// byteRange is an inclusive range of object bytes
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// Parses the Range header against the object of the given size.
+// Returns no ranges when the whole object must be sent: there is no header,
+// the header is malformed or the ranges cover more than the object itself.
+// Returns ErrInvalidRange when none of the ranges is satisfiable
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	const unitPrefix = "bytes="
+	if !strings.HasPrefix(header, unitPrefix) {
+		return nil, nil
+	}
+
+	ranges := []byteRange{}
+	unsatisfiable := false
+	for _, spec := range strings.Split(header[len(unitPrefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		startValue, endValue, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, nil
+		}
+		startValue, endValue = strings.TrimSpace(startValue), strings.TrimSpace(endValue)
+
+		// Suffix range, the last N bytes
+		if startValue == "" {
+			suffixLength, err := strconv.ParseInt(endValue, 10, 64)
+			if err != nil || suffixLength < 0 {
+				return nil, nil
+			}
+			if suffixLength == 0 || size == 0 {
+				unsatisfiable = true
+				continue
+			}
+			if suffixLength > size {
+				suffixLength = size
+			}
+			ranges = append(ranges, byteRange{start: size - suffixLength, end: size - 1})
+			continue
+		}
+
+		start, err := strconv.ParseInt(startValue, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+		end := size - 1
+		// Closed range, otherwise open-ended till the end of the object
+		if endValue != "" {
+			end, err = strconv.ParseInt(endValue, 10, 64)
+			if err != nil || end < start {
+				return nil, nil
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			unsatisfiable = true
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+
+	if len(ranges) == 0 {
+		if unsatisfiable {
+			return nil, ErrInvalidRange
+		}
+		return nil, nil
+	}
+
+	// Overlapping ranges requesting more than the object are answered with the whole object
+	var total int64
+	for _, byteRange := range ranges {
+		total += byteRange.length()
+	}
+	if total > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// Writes the ranges of the content as 206 Partial Content response,
+// a single range is sent as is and several ones as multipart/byteranges
+func writeRanges(w http.ResponseWriter, content io.ReaderAt, size int64, contentType string, ranges []byteRange) error {
+	if len(ranges) == 1 {
+		w.Header().Set("Content-Range", ranges[0].contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(ranges[0].length(), 10))
+		w.WriteHeader(http.StatusPartialContent)
+		_, err := io.Copy(w, io.NewSectionReader(content, ranges[0].start, ranges[0].length()))
+		return err
+	}
+
+	multipartWriter := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+multipartWriter.Boundary())
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusPartialContent)
+	for _, byteRange := range ranges {
+		partHeader := textproto.MIMEHeader{}
+		partHeader.Set("Content-Type", contentType)
+		partHeader.Set("Content-Range", byteRange.contentRange(size))
+		partWriter, err := multipartWriter.CreatePart(partHeader)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(partWriter, io.NewSectionReader(content, byteRange.start, byteRange.length()))
+		if err != nil {
+			return err
+		}
+	}
+	return multipartWriter.Close()
+}
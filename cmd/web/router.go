@@ -6,43 +6,91 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
 // Errors
 var (
-	ErrInvalidCharacters    = errors.New("object/bucket name contains invalid characters")
-	ErrValidIPAddress       = errors.New("object/bucket name is valid IP address, IP address is not allowed")
-	ErrTooShortName         = errors.New("object/bucket name is too short, must be longer than 3 characters")
-	ErrTooLongName          = errors.New("object/bucket name is too long, must be shorter than 63 characters")
-	ErrStartWithHyphen      = errors.New("object/bucket name cannot start with hyphen or dot")
-	ErrEndWithHyphenDot     = errors.New("object/bucket name cannot end with hyphen or dot")
-	ErrConsecutiveHyphenDot = errors.New("object/bucket name has consecutive hyphens or dots")
-	ErrManySegments         = errors.New("too many segments in URL string, must be less 3")
+	ErrInvalidCharacters    = errors.New("bucket name contains invalid characters")
+	ErrValidIPAddress       = errors.New("bucket name is valid IP address, IP address is not allowed")
+	ErrTooShortName         = errors.New("bucket name is too short, must be longer than 3 characters")
+	ErrTooLongName          = errors.New("bucket name is too long, must be shorter than 63 characters")
+	ErrStartWithHyphen      = errors.New("bucket name cannot start with hyphen or dot")
+	ErrEndWithHyphenDot     = errors.New("bucket name cannot end with hyphen or dot")
+	ErrConsecutiveHyphenDot = errors.New("bucket name has consecutive hyphens or dots")
+	ErrEmptyObjectKey       = errors.New("object key cannot be empty")
+	ErrTooLongObjectKey     = errors.New("object key is too long, must be at most 1024 bytes")
+	ErrInvalidObjectKey     = errors.New("object key must be a valid UTF-8 string")
 	ErrNoSuchResource       = errors.New("the specified resource doesn't exist")
 	ErrMethodNotAllowed     = errors.New("the specified method is not allowed against this resource")
 )
 
-func Routes() *http.ServeMux {
-	mux := http.NewServeMux()
-	// routerHandler handles all routes
-	mux.HandleFunc("/", routerHandler)
+// Maximum length of the object key in bytes
+const maxObjectKeyLength = 1024
 
-	return mux
+// Routes returns the handler of the whole API.
+// http.ServeMux is not used as it cleans the path and would redirect
+// object keys containing "//", "./" or "../" segments
+func Routes() http.Handler {
+	// routerHandler handles all routes
+	return http.HandlerFunc(routerHandler)
 }
 
-// Validate the bucket name to ensure it meets Amazon S3 naming requirements (3-63 characters, only lowercase letters, numbers, hyphens, and periods).
-
 // Router handler
 func routerHandler(w http.ResponseWriter, r *http.Request) {
-	// Dividing URL into segments
-	URLStringTrimmed := strings.Trim(r.URL.String(), "/")
-	URLSegments := strings.Split(URLStringTrimmed, "/")
+	// Dividing URL into bucket name and object key, the key may contain slashes
+	URLSegments := splitURLPath(r.URL.Path)
 	log.Printf("%s request with URL: %s", r.Method, r.URL.String())
 
+	// CORS preflight and the CORS headers of the actual requests on buckets and objects
+	if len(URLSegments) > 0 {
+		if r.Method == http.MethodOptions {
+			corsPreflightHandler(w, r, URLSegments[0])
+			return
+		}
+		applyCORS(w, r, URLSegments[0])
+	}
+
+	// Signature Version 4 authentication
+	authenticated, err := authenticateRequest(r)
+	if err != nil {
+		respondError(w, r, authErrorStatusCode(err), err)
+		return
+	}
+	r = authenticated
+
+	// Admin routes
+	if strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+		adminHandler(w, r)
+		return
+	}
+
+	// Authorization against the policies and the ACLs
+	if action := requestAction(r, URLSegments); action != "" {
+		bucketName, objectKey, versionID := "", "", ""
+		if len(URLSegments) > 0 {
+			bucketName = URLSegments[0]
+		}
+		if len(URLSegments) == 2 {
+			objectKey, versionID = URLSegments[1], r.URL.Query().Get("versionId")
+		}
+		err := authorize(r, action, bucketName, objectKey, versionID)
+		if err != nil {
+			statusCode := http.StatusForbidden
+			if err == ErrNoSuchVersion {
+				statusCode = http.StatusNotFound
+			} else if err == ErrInvalidVersionID {
+				statusCode = http.StatusBadRequest
+			}
+			respondError(w, r, statusCode, err)
+			return
+		}
+	}
+
 	// Routing
 	switch {
 	// / Index route processing
-	case r.URL.String() == "/":
+	case len(URLSegments) == 0:
 		if r.Method == http.MethodGet {
 			getBuckets(w)
 			return
@@ -54,15 +102,83 @@ func routerHandler(w http.ResponseWriter, r *http.Request) {
 	// /<BucketName> route processing
 	case len(URLSegments) == 1:
 		// URL validation
-		err := validateURLSegments(URLSegments)
+		err := validateBucketName(URLSegments[0])
 		if err != nil {
 			respondError(w, r, http.StatusBadRequest, err)
 			return
 		}
 
+		if r.URL.Query().Has("policy") {
+			bucketConfigHandler(w, r, URLSegments[0], bucketPolicyRoutes)
+			return
+		}
+		if r.URL.Query().Has("versioning") {
+			bucketConfigHandler(w, r, URLSegments[0], bucketVersioningRoutes)
+			return
+		}
+		if r.URL.Query().Has("lifecycle") {
+			bucketConfigHandler(w, r, URLSegments[0], bucketLifecycleRoutes)
+			return
+		}
+		if r.URL.Query().Has("cors") {
+			bucketConfigHandler(w, r, URLSegments[0], bucketCORSRoutes)
+			return
+		}
+		if r.URL.Query().Has("website") {
+			bucketConfigHandler(w, r, URLSegments[0], bucketWebsiteRoutes)
+			return
+		}
+
 		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Has("uploads") {
+				err := listMultipartUploads(w, r, URLSegments[0])
+				if err != nil {
+					statusCode := http.StatusBadRequest
+					if err == ErrBucketNotExists {
+						statusCode = http.StatusNotFound
+					}
+					respondError(w, r, statusCode, err)
+				}
+				return
+			}
+			if r.URL.Query().Has("versions") {
+				err := listObjectVersions(w, r, URLSegments[0])
+				if err != nil {
+					statusCode := http.StatusBadRequest
+					if err == ErrBucketNotExists {
+						statusCode = http.StatusNotFound
+					}
+					respondError(w, r, statusCode, err)
+				}
+				return
+			}
+			err := listObjects(w, r, URLSegments[0])
+			if err != nil {
+				statusCode := http.StatusBadRequest
+				if err == ErrBucketNotExists {
+					statusCode = http.StatusNotFound
+				}
+				respondError(w, r, statusCode, err)
+				return
+			}
+			return
+		case http.MethodHead:
+			err := headBucket(URLSegments[0])
+			if err != nil {
+				statusCode := http.StatusBadRequest
+				if err == ErrBucketNotExists {
+					statusCode = http.StatusNotFound
+				}
+				respondError(w, r, statusCode, err)
+				return
+			}
+			return
 		case http.MethodPut:
-			err := createBucket(URLSegments[0])
+			acl, err := cannedACLFromRequest(r)
+			if err == nil {
+				err = createBucket(URLSegments[0], acl)
+			}
 			if err != nil {
 				statusCode := http.StatusBadRequest
 				if err == ErrBucketAlreadyExists {
@@ -76,6 +192,21 @@ func routerHandler(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Connection", "close")
 			w.Write([]byte("Created the bucket with name: " + URLSegments[0] + "\n"))
 			return
+		case http.MethodPost:
+			if !r.URL.Query().Has("delete") {
+				w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+				respondError(w, r, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+				return
+			}
+			err := deleteObjects(w, r, URLSegments[0])
+			if err != nil {
+				statusCode := http.StatusBadRequest
+				if err == ErrBucketNotExists {
+					statusCode = http.StatusNotFound
+				}
+				respondError(w, r, statusCode, err)
+			}
+			return
 		case http.MethodDelete:
 			err := deleteBucket(URLSegments[0])
 			if err != nil {
@@ -95,50 +226,135 @@ func routerHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		default:
-			w.Header().Set("Allow", "PUT, DELETE")
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
 			respondError(w, r, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
 			return
 		}
 	// /<BucketName>/<ObjectName> route
 	case len(URLSegments) == 2:
 		// URL validation
-		err := validateURLSegments(URLSegments)
+		err := validateBucketName(URLSegments[0])
+		if err == nil {
+			err = validateObjectKey(URLSegments[1])
+		}
 		if err != nil {
 			respondError(w, r, http.StatusBadRequest, err)
 			return
 		}
 
+		query := r.URL.Query()
+		if query.Has("tagging") {
+			objectTaggingHandler(w, r, URLSegments[0], URLSegments[1])
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
-			err := retrieveObject(w, URLSegments[0], URLSegments[1])
+			if query.Has("uploadId") {
+				err := listParts(w, r, URLSegments[0], URLSegments[1])
+				if err != nil {
+					respondError(w, r, multipartErrorStatusCode(err), err)
+				}
+				return
+			}
+			err := retrieveObject(w, r, URLSegments[0], URLSegments[1])
 			if err != nil {
-				statusCode := 400
-				if err == ErrObjectNotExists {
-					statusCode = http.StatusNotFound
+				if err == ErrNotModified {
+					w.WriteHeader(http.StatusNotModified)
+					return
 				}
-				respondError(w, r, statusCode, err)
+				respondError(w, r, versionErrorStatusCode(w, err), err)
 				return
 
 			}
 			return
+		case http.MethodHead:
+			err := headObject(w, r, URLSegments[0], URLSegments[1])
+			if err != nil {
+				if err == ErrNotModified {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				respondError(w, r, versionErrorStatusCode(w, err), err)
+				return
+			}
+			return
 		case http.MethodPut:
-			err := uploadObject(r, URLSegments[0], URLSegments[1])
+			if query.Has("uploadId") {
+				etag, err := uploadObjectPart(r, URLSegments[0], URLSegments[1])
+				if err != nil {
+					respondError(w, r, multipartErrorStatusCode(err), err)
+					return
+				}
+				w.Header().Set("ETag", quoteETag(etag))
+				w.Header().Set("Content-Length", "0")
+				return
+			}
+			if r.Header.Get("X-Amz-Copy-Source") != "" {
+				err := copyObject(w, r, URLSegments[0], URLSegments[1])
+				if err != nil {
+					statusCode := http.StatusBadRequest
+					if err == ErrObjectNotExists || err == ErrBucketNotExists || err == ErrNoSuchVersion {
+						statusCode = http.StatusNotFound
+					} else if err == ErrPreconditionFailed || err == ErrObjectAlreadyExists {
+						statusCode = http.StatusPreconditionFailed
+					} else if err == ErrAccessDenied {
+						statusCode = http.StatusForbidden
+					}
+					respondError(w, r, statusCode, err)
+				}
+				return
+			}
+			object, err := uploadObject(r, URLSegments[0], URLSegments[1])
 			if err != nil {
 				statusCode := 400
-				if err == ErrObjectAlreadyExists {
-					statusCode = http.StatusConflict
+				if err == ErrObjectAlreadyExists || err == ErrPreconditionFailed {
+					statusCode = http.StatusPreconditionFailed
+				} else if err == ErrObjectNotExists || err == ErrBucketNotExists {
+					statusCode = http.StatusNotFound
 				}
 				respondError(w, r, statusCode, err)
 				return
 			}
+			w.Header().Set("ETag", quoteETag(object.etag))
+			if object.versionID != "" {
+				w.Header().Set("X-Amz-Version-Id", object.versionID)
+			}
 			w.Header().Set("Content-Length", "0")
 			w.Header().Set("Connection", "close")
 			return
+		case http.MethodPost:
+			var err error
+			if query.Has("uploads") {
+				err = createMultipartUpload(w, r, URLSegments[0], URLSegments[1])
+			} else if query.Has("uploadId") {
+				err = completeMultipartUpload(w, r, URLSegments[0], URLSegments[1])
+			} else {
+				w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+				err = ErrMethodNotAllowed
+			}
+			if err != nil {
+				statusCode := multipartErrorStatusCode(err)
+				if err == ErrMethodNotAllowed {
+					statusCode = http.StatusMethodNotAllowed
+				}
+				respondError(w, r, statusCode, err)
+			}
+			return
 		case http.MethodDelete:
-			err := deleteObject(URLSegments[0], URLSegments[1])
+			if query.Has("uploadId") {
+				err := abortMultipartUpload(URLSegments[0], URLSegments[1], query.Get("uploadId"))
+				if err != nil {
+					respondError(w, r, multipartErrorStatusCode(err), err)
+					return
+				}
+				w.Header().Set("Content-Length", "0")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			err := deleteObject(w, r, URLSegments[0], URLSegments[1])
 			if err != nil {
 				statusCode := 400
-				if err == ErrObjectNotExists || err == ErrBucketNotExists {
+				if err == ErrObjectNotExists || err == ErrBucketNotExists || err == ErrNoSuchVersion {
 					statusCode = http.StatusNotFound
 				}
 				respondError(w, r, statusCode, err)
@@ -150,9 +366,8 @@ func routerHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Header().Set("Allow", "GET, PUT, DELETE")
-			w.Write([]byte("Incorrect method entered to operate with objects\n"))
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			respondError(w, r, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
 			return
 		}
 	default:
@@ -161,71 +376,273 @@ func routerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Validation of URL variables
-func validateURLSegments(URLSegments []string) error {
-	if len(URLSegments) > 2 {
-		return ErrManySegments
-	} else if len(URLSegments) == 0 {
-		return nil
-	}
+// Handlers of a bucket configuration subresource
+type bucketConfigRoutes struct {
+	get func(w http.ResponseWriter, bucketName string) error
+	put func(r *http.Request, bucketName string) error
+	// Nil when the configuration cannot be deleted
+	delete func(bucketName string) error
+	// Error of the missing configuration, it is answered with 404
+	errNoSuchConfig error
+	// Status code of the successful PUT response
+	putStatusCode int
+}
 
-	// Regex Patterns
-	CharactersPattern, err := regexp.Compile("^[a-z0-9.-]+$")
-	if err != nil {
-		return err
+var (
+	bucketPolicyRoutes = bucketConfigRoutes{
+		get:             getBucketPolicy,
+		put:             putBucketPolicy,
+		delete:          deleteBucketPolicy,
+		errNoSuchConfig: ErrNoSuchBucketPolicy,
+		putStatusCode:   http.StatusNoContent,
 	}
-
-	IPPattern, err := regexp.Compile(`^(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)$`)
-	if err != nil {
-		return err
+	bucketVersioningRoutes = bucketConfigRoutes{
+		get:           getBucketVersioning,
+		put:           putBucketVersioning,
+		putStatusCode: http.StatusOK,
 	}
-	StartHyphenDotPattern, err := regexp.Compile(`^[.-]`)
-	if err != nil {
-		return err
+	bucketLifecycleRoutes = bucketConfigRoutes{
+		get:             getBucketLifecycle,
+		put:             putBucketLifecycle,
+		delete:          deleteBucketLifecycle,
+		errNoSuchConfig: ErrNoSuchLifecycleConfiguration,
+		putStatusCode:   http.StatusOK,
 	}
-	EndHyphenDotPattern, err := regexp.Compile(`[.-]$`)
-	if err != nil {
-		return err
+	bucketCORSRoutes = bucketConfigRoutes{
+		get:             getBucketCORS,
+		put:             putBucketCORS,
+		delete:          deleteBucketCORS,
+		errNoSuchConfig: ErrNoSuchCORSConfiguration,
+		putStatusCode:   http.StatusOK,
 	}
-	ConsecutiveDotsHyphenPattern, err := regexp.Compile(`(\.\.|--)`)
-	if err != nil {
-		return err
+	bucketWebsiteRoutes = bucketConfigRoutes{
+		get:             getBucketWebsite,
+		put:             putBucketWebsite,
+		delete:          deleteBucketWebsite,
+		errNoSuchConfig: ErrNoSuchWebsiteConfiguration,
+		putStatusCode:   http.StatusOK,
 	}
+)
 
-	// bucket and object name validation
-	for _, segment := range URLSegments {
-		segmentBytes := []byte(segment)
-
-		// length validation
-		if len(segment) > 63 {
-			return ErrTooLongName
-		} else if len(segment) < 3 {
-			return ErrTooShortName
+// /<BucketName>?policy, ?versioning and the other bucket configuration routes handler
+func bucketConfigHandler(w http.ResponseWriter, r *http.Request, bucketName string, routes bucketConfigRoutes) {
+	var err error
+	switch {
+	case r.Method == http.MethodGet:
+		err = routes.get(w, bucketName)
+	case r.Method == http.MethodPut:
+		err = routes.put(r, bucketName)
+		if err == nil {
+			w.WriteHeader(routes.putStatusCode)
+		}
+	case r.Method == http.MethodDelete && routes.delete != nil:
+		err = routes.delete(bucketName)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		allow := "GET, PUT"
+		if routes.delete != nil {
+			allow += ", DELETE"
+		}
+		w.Header().Set("Allow", allow)
+		respondError(w, r, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		statusCode := http.StatusBadRequest
+		if err == ErrBucketNotExists || err == routes.errNoSuchConfig {
+			statusCode = http.StatusNotFound
 		}
+		respondError(w, r, statusCode, err)
+	}
+}
 
-		// characters validation
-		if match := CharactersPattern.Match(segmentBytes); !match {
-			return ErrInvalidCharacters
+// OPTIONS /<BucketName> and /<BucketName>/<ObjectName> preflight handler
+func corsPreflightHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	err := preflightCORS(w, r, bucketName)
+	if err != nil {
+		statusCode := http.StatusBadRequest
+		switch err {
+		case ErrBucketNotExists:
+			statusCode = http.StatusNotFound
+		case ErrCORSNotAllowed, ErrMissingCORSHeaders:
+			statusCode = http.StatusForbidden
 		}
+		respondError(w, r, statusCode, err)
+	}
+}
 
-		// ip validation
-		if match := IPPattern.Match(segmentBytes); match {
-			return ErrValidIPAddress
+// /<BucketName>/<ObjectName>?tagging routes handler
+func objectTaggingHandler(w http.ResponseWriter, r *http.Request, bucketName, objectName string) {
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		err = getObjectTagging(w, r, bucketName, objectName)
+	case http.MethodPut:
+		err = putObjectTagging(w, r, bucketName, objectName)
+	case http.MethodDelete:
+		err = deleteObjectTagging(w, r, bucketName, objectName)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
 		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		respondError(w, r, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		respondError(w, r, versionErrorStatusCode(w, err), err)
+	}
+}
+
+// Status code of the errors returned while reading a version of the object
+func versionErrorStatusCode(w http.ResponseWriter, err error) int {
+	switch err {
+	case ErrObjectNotExists, ErrBucketNotExists, ErrNoSuchVersion:
+		return http.StatusNotFound
+	case ErrVersionIsDeleteMarker:
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+		return http.StatusMethodNotAllowed
+	case ErrInvalidRange:
+		return http.StatusRequestedRangeNotSatisfiable
+	case ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusBadRequest
+	}
+}
 
-		// begin with dot/hyphen validation
-		if match := StartHyphenDotPattern.Match(segmentBytes); match {
-			return ErrStartWithHyphen
+// Admin routes handler
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	adminPath := strings.TrimPrefix(r.URL.Path, adminPathPrefix)
+	switch {
+	case adminPath == "presign":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			respondError(w, r, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+			return
 		}
-		// end with dot/hyphen validation
-		if match := EndHyphenDotPattern.Match(segmentBytes); match {
-			return ErrEndWithHyphenDot
+		err := presignObject(w, r)
+		if err != nil {
+			respondError(w, r, http.StatusBadRequest, err)
 		}
-		// consecutive dots/hyphens
-		if match := ConsecutiveDotsHyphenPattern.Match(segmentBytes); match {
-			return ErrConsecutiveHyphenDot
+	case adminPath == "users" || strings.HasPrefix(adminPath, "users/"):
+		segments := strings.Split(strings.TrimSuffix(adminPath, "/"), "/")[1:]
+		err := manageUsers(w, r, segments)
+		if err != nil {
+			respondError(w, r, iamErrorStatusCode(err), err)
 		}
+	default:
+		respondError(w, r, http.StatusNotFound, ErrNoSuchResource)
+	}
+}
+
+// Status code of the errors returned by authentication
+func authErrorStatusCode(err error) int {
+	switch err {
+	case ErrAuthorizationHeaderMalformed, ErrAuthorizationQueryMalformed:
+		return http.StatusBadRequest
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// Status code of the errors returned by user registry handlers
+func iamErrorStatusCode(err error) int {
+	switch err {
+	case ErrAccessDenied:
+		return http.StatusForbidden
+	case ErrNoSuchUser, ErrNoSuchAccessKey, ErrNoSuchPolicy, ErrNoSuchResource:
+		return http.StatusNotFound
+	case ErrUserAlreadyExists:
+		return http.StatusConflict
+	case ErrMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// Status code of the errors returned by multipart upload handlers
+func multipartErrorStatusCode(err error) int {
+	switch err {
+	case ErrBucketNotExists, ErrNoSuchUpload:
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// Splits the URL path into the bucket name and the object key.
+// Returns no segments for the index route, a trailing slash after the bucket name is ignored
+func splitURLPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	segments := strings.SplitN(path, "/", 2)
+	if len(segments) == 2 && segments[1] == "" {
+		return segments[:1]
+	}
+	return segments
+}
+
+// Regex patterns of the bucket name rules
+var (
+	bucketCharactersPattern      = regexp.MustCompile("^[a-z0-9.-]+$")
+	IPPattern                    = regexp.MustCompile(`^(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)$`)
+	StartHyphenDotPattern        = regexp.MustCompile(`^[.-]`)
+	EndHyphenDotPattern          = regexp.MustCompile(`[.-]$`)
+	ConsecutiveDotsHyphenPattern = regexp.MustCompile(`(\.\.|--)`)
+)
+
+// Validate the bucket name to ensure it meets Amazon S3 naming requirements (3-63 characters, only lowercase letters, numbers, hyphens, and periods).
+func validateBucketName(bucketName string) error {
+	bucketNameBytes := []byte(bucketName)
+
+	// length validation
+	if len(bucketName) > 63 {
+		return ErrTooLongName
+	} else if len(bucketName) < 3 {
+		return ErrTooShortName
 	}
 
+	// characters validation
+	if match := bucketCharactersPattern.Match(bucketNameBytes); !match {
+		return ErrInvalidCharacters
+	}
+
+	// ip validation
+	if match := IPPattern.Match(bucketNameBytes); match {
+		return ErrValidIPAddress
+	}
+
+	// begin with dot/hyphen validation
+	if match := StartHyphenDotPattern.Match(bucketNameBytes); match {
+		return ErrStartWithHyphen
+	}
+	// end with dot/hyphen validation
+	if match := EndHyphenDotPattern.Match(bucketNameBytes); match {
+		return ErrEndWithHyphenDot
+	}
+	// consecutive dots/hyphens
+	if match := ConsecutiveDotsHyphenPattern.Match(bucketNameBytes); match {
+		return ErrConsecutiveHyphenDot
+	}
+
+	return nil
+}
+
+// Validate the object key, it may be any UTF-8 string up to 1024 bytes.
+// Keys never reach the filesystem as is, see objectPath
+func validateObjectKey(objectKey string) error {
+	if len(objectKey) == 0 {
+		return ErrEmptyObjectKey
+	} else if len(objectKey) > maxObjectKeyLength {
+		return ErrTooLongObjectKey
+	} else if !utf8.ValidString(objectKey) {
+		return ErrInvalidObjectKey
+	}
 	return nil
 }
@@ -1,15 +1,10 @@
 package web
 
 import (
-	"encoding/csv"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
-	"time"
 )
 
 // Initialization loading buckets
@@ -19,186 +14,119 @@ func Init() error {
 	if err != nil {
 		return err
 	}
-	// Initialize Buckets map
-	if len(bucketMap) == 0 {
-		bucketMap = make(map[string]*bucketData)
+	if credentialsPath != "" {
+		credentials, err = loadCredentials(credentialsPath)
+		if err != nil {
+			return err
+		}
 	}
-
+	backend = newFSBackend(storagePath)
 	err = loadBucketsData()
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("error while loading buckets metadata: %w", err)
 	}
-	return nil
-}
 
-// Load buckets from data path if exist
-// if doesn't exist, creates new buckets.csv
-func loadBucketsData() error {
-	// Validate storage path
-	for _, prohibitedPath := range ProhibitedStoragePaths {
-		if prohibitedPath == strings.Trim(storagePath, "/") {
-			return ErrProhibitedStoragePath
-		}
+	// Users and their access keys
+	registry, err = loadIAMRegistry(filepath.Join(storagePath, iamDirName))
+	if err != nil {
+		return fmt.Errorf("error while loading the user registry: %w", err)
 	}
 
-	// Create storage directory if not exists
-	err := os.Mkdir(storagePath, 0o755)
+	// Repair the mutations interrupted by a crash
+	var transactions []journalTransaction
+	journal, transactions, err = openJournal(filepath.Join(storagePath, journalFileName))
 	if err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("error while creating storage directory: %w", err)
-		}
-	} else {
-		log.Print("created storage directory: " + storagePath)
+		return fmt.Errorf("error while opening the journal: %w", err)
 	}
-
-	// Opening buckets.csv metadata file
-	bucketsMetadataPath := filepath.Join(storagePath, "buckets.csv")
-	bucketsFile, err := os.OpenFile(bucketsMetadataPath, os.O_RDONLY, 0o644)
+	err = recoverJournal(transactions)
 	if err != nil {
-		if os.IsNotExist(err) {
-			_, err := os.OpenFile(bucketsMetadataPath, os.O_CREATE, 0o644)
-			if err != nil {
-				log.Fatal(err)
-			} else {
-				log.Print("created buckets.csv metadata file")
-				return nil
-			}
-		}
-		return fmt.Errorf("error while opening bucket metadata file: %w", err)
+		return fmt.Errorf("error while recovering the journal: %w", err)
 	}
-	defer bucketsFile.Close()
-
-	// Validation must be in router, so here it is skipped
-	// so here bucketName is not validated
 
-	// Parsing buckets.csv file
-	bucketsCsvReader := csv.NewReader(bucketsFile)
+	// Staged multipart uploads and their sweeper
+	err = loadMultipartUploads()
+	if err != nil {
+		return fmt.Errorf("error while loading multipart uploads: %w", err)
+	}
+	go sweepMultipartUploads()
+	if scrubInterval > 0 {
+		go scrubObjects()
+	}
+	if lifecycleInterval > 0 {
+		go expireObjects()
+	}
+	return nil
+}
 
-	// Iterate over csv records
-	for {
-		bucketsRecord, err := bucketsCsvReader.Read()
-		if err != nil {
-			if err == io.EOF {
-				log.Print("loaded buckets metadata")
-				return nil
-			}
-			return fmt.Errorf("error while reading buckets' metadata: %w", err)
-			// csv record length validation
-		} else if len(bucketsRecord) != 4 {
-			return ErrInvalidNumberOfFields
-			// duplication detection
-		} else if _, exists := bucketMap[bucketsRecord[0]]; exists {
+// Loads the buckets of the backend into the store
+func loadBucketsData() error {
+	buckets, err := backend.LoadBuckets()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		// duplication detection
+		if _, exists := store.buckets[bucket.Name]; exists {
 			return ErrBucketAlreadyExists
 		}
-
-		// Object's metadata reading
-		objects := []bucketObject{}
-		bucketPath := filepath.Join(storagePath, bucketsRecord[0])
-		objectMetaDataPath := filepath.Join(bucketPath, "objects.csv")
-		objectMetaDataFile, err := os.OpenFile(objectMetaDataPath, os.O_RDONLY, 0o644)
+		err = loadBucketPolicy(bucket)
 		if err != nil {
-			if os.IsNotExist(err) {
-				_, err = os.OpenFile(objectMetaDataPath, os.O_CREATE, 0o644)
-				if err != nil {
-					return fmt.Errorf("error while reading the <%s> bucket and creating its metadata file: %w", bucketsRecord[0], err)
-				}
-				continue
-			}
-			return fmt.Errorf("error while reading <%s> object metadata file: %w", bucketsRecord[0], err)
+			return err
 		}
-		bucketCsvReader := csv.NewReader(objectMetaDataFile)
-		for {
-			bucketRecord, err := bucketCsvReader.Read()
-			if err != nil {
-				if err == io.EOF {
-					break
-				} else {
-					return fmt.Errorf("error while reading <%s> bucket's metadata file: %w", bucketsRecord[0], err)
-				}
-			}
-
-			length, err := strconv.Atoi(bucketRecord[1])
-			if err != nil {
-				return fmt.Errorf("error while converting <%s> object length to integer: %w", bucketRecord[0], err)
-			}
-			objects = append(objects, bucketObject{
-				objectKey:     bucketRecord[0],
-				contentLength: length,
-				contentType:   bucketRecord[2],
-				lastModified:  bucketRecord[3],
-			})
+		err = loadBucketLifecycle(bucket)
+		if err != nil {
+			return err
 		}
-		objectMetaDataFile.Close()
-
-		// add bucket to bucket map
-		bucketMap[bucketsRecord[0]] = &bucketData{
-			Name:             bucketsRecord[0],
-			CreatedTime:      bucketsRecord[1],
-			LastModifiedTime: bucketsRecord[2],
-			Status:           bucketsRecord[3],
-			objects:          &objects,
+		err = loadBucketCORS(bucket)
+		if err != nil {
+			return err
 		}
-	}
-}
-
-// Global variable of buckets list
-// key string is the name of the bucket
-var bucketMap map[string]*bucketData
-
-func saveBucketsData() error {
-	// Opening buckets.csv metadata file
-	bucketsMetadataPath := filepath.Join(storagePath, "buckets.csv")
-	bucketsFile, err := os.Create(bucketsMetadataPath)
-	if err != nil {
-		return fmt.Errorf("error while opening bucket metadata file: %w", err)
-	}
-	defer bucketsFile.Close()
-
-	csvWriter := csv.NewWriter(bucketsFile)
-	for bucketName, bucketData := range bucketMap {
-		err := csvWriter.Write([]string{bucketName, bucketData.CreatedTime, bucketData.LastModifiedTime, bucketData.Status})
+		err = loadBucketWebsite(bucket)
 		if err != nil {
-			return fmt.Errorf("error while saving bucket's metadata to buckets.csv file")
+			return err
 		}
+		bucket.loadStats()
+		store.buckets[bucket.Name] = bucket
 	}
-	csvWriter.Flush()
-	if err := csvWriter.Error(); err != nil {
-		return fmt.Errorf("error while saving bucket's metadata to buckets.csv file: %w", err)
-	}
-
+	log.Print("loaded buckets metadata")
 	return nil
 }
 
-func saveObjectsData(bucketName, objectName string) error {
-	bucketMetadataPath := filepath.Join(storagePath, bucketName, "objects.csv")
-	bucketMetadataFile, err := os.Create(bucketMetadataPath)
-	if err != nil {
-		return fmt.Errorf("error while reading <%s> bucket metadata file: %w", bucketName, err)
+// Persists the records of all buckets, the caller must hold the store lock for writing
+func saveBucketsData() error {
+	buckets := make([]*bucketData, 0, len(store.buckets))
+	for _, bucketName := range store.sortedNames() {
+		buckets = append(buckets, store.buckets[bucketName])
 	}
+	return backend.SaveBuckets(buckets)
+}
 
-	csvWriter := csv.NewWriter(bucketMetadataFile)
-	for _, object := range *bucketMap[bucketName].objects {
-		csvWriter.Write([]string{object.objectKey, strconv.Itoa(object.contentLength), object.contentType, object.lastModified})
+// Replaces a configuration of the bucket and persists the buckets metadata.
+// Set assigns the configuration and returns the function restoring the previous one if it is not saved
+func setBucketConfig(bucketName string, set func(bucket *bucketData) (restore func())) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	bucket, exists := store.buckets[bucketName]
+	if !exists {
+		return ErrBucketNotExists
 	}
-
-	csvWriter.Flush()
-	err = csvWriter.Error()
+	restore := set(bucket)
+	err := saveBucketsData()
 	if err != nil {
-		return fmt.Errorf("error while writing metadata to <%s> file: %w", bucketMetadataPath, err)
+		restore()
+		return err
 	}
+	return nil
+}
 
-	// Update metadata in buckets.csv file
-	if len(*bucketMap[bucketName].objects) == 0 {
-		bucketMap[bucketName].Status = "inactive"
-	} else {
-		bucketMap[bucketName].Status = "active"
-	}
-	bucketMap[bucketName].LastModifiedTime = time.Now().Format(time.RFC822)
-	// Sync with disk files
-	err = saveBucketsData()
+// Persists the changes of the bucket's objects metadata, the caller must hold the bucket lock for writing
+// and have applied the changes to the index. The store lock is not taken, so the objects of different
+// buckets are saved in parallel, and buckets.csv is left as is as the status of the bucket follows in memory
+func saveObjectsData(bucket *bucketData, changes ...objectChange) error {
+	err := backend.SaveObjects(bucket.Name, bucket.objects, changes)
 	if err != nil {
-		return fmt.Errorf("error while saving buckets in <%s> object and <%s> bucket metadata: %w", objectName, bucketName, err)
+		return err
 	}
-
+	bucket.touch()
 	return nil
 }
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"sort"
+	"sync"
+)
+
+// bucketStore owns the buckets of the storage.
+// mu guards the map itself and the configurations of the buckets written to buckets.csv,
+// the objects of a bucket are guarded by the bucket's own lock, so different buckets are used in parallel.
+// Locks are always taken in the bucket then store order
+type bucketStore struct {
+	mu      sync.RWMutex
+	buckets map[string]*bucketData
+}
+
+// Global store of the buckets
+var store = newBucketStore()
+
+func newBucketStore() *bucketStore {
+	return &bucketStore{buckets: map[string]*bucketData{}}
+}
+
+// Returns the bucket without locking it
+func (s *bucketStore) get(bucketName string) (*bucketData, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bucket, exists := s.buckets[bucketName]
+	return bucket, exists
+}
+
+// Returns the bucket locked for reading, the caller must release bucket.mu.RUnlock
+func (s *bucketStore) rlockBucket(bucketName string) (*bucketData, error) {
+	bucket, exists := s.get(bucketName)
+	if !exists {
+		return nil, ErrBucketNotExists
+	}
+	bucket.mu.RLock()
+	// The bucket may be deleted while waiting for the lock
+	if bucket.deleted {
+		bucket.mu.RUnlock()
+		return nil, ErrBucketNotExists
+	}
+	return bucket, nil
+}
+
+// Returns the bucket locked for writing, the caller must release bucket.mu.Unlock
+func (s *bucketStore) lockBucket(bucketName string) (*bucketData, error) {
+	bucket, exists := s.get(bucketName)
+	if !exists {
+		return nil, ErrBucketNotExists
+	}
+	bucket.mu.Lock()
+	if bucket.deleted {
+		bucket.mu.Unlock()
+		return nil, ErrBucketNotExists
+	}
+	return bucket, nil
+}
+
+// Returns the sorted names of all buckets
+func (s *bucketStore) names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedNames()
+}
+
+// Returns the sorted names of all buckets, the caller must hold the store lock
+func (s *bucketStore) sortedNames() []string {
+	names := make([]string, 0, len(s.buckets))
+	for bucketName := range s.buckets {
+		names = append(names, bucketName)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,306 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Serves the router over an empty store kept by the memory backend
+func newTestRouter(t *testing.T) http.Handler {
+	t.Helper()
+	backend = newMemoryBackend()
+	store = newBucketStore()
+	journal = nil
+	credentials = nil
+	return Routes()
+}
+
+// Sends the request through the router and returns the response
+func doRequest(router http.Handler, method, target, body string) *http.Response {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return recorder.Result()
+}
+
+func responseBody(t *testing.T, response *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("error while reading the response body: %s", err)
+	}
+	return string(body)
+}
+
+// Reports a status code which is not one of the expected ones
+func expectStatus(t *testing.T, response *http.Response, request string, expected ...int) {
+	t.Helper()
+	for _, statusCode := range expected {
+		if response.StatusCode == statusCode {
+			return
+		}
+	}
+	t.Errorf("%s: got status %d, expected one of %v", request, response.StatusCode, expected)
+}
+
+// Checks that the objects of the bucket and the stored contents match each other
+func checkBucketContents(t *testing.T, bucketName string) {
+	t.Helper()
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		t.Fatalf("<%s> bucket: %s", bucketName, err)
+	}
+	defer bucket.mu.RUnlock()
+
+	memory := backend.(*memoryBackend)
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	contents := memory.buckets[bucketName].contents
+	if bucket.objects.len() != len(contents) {
+		t.Errorf("<%s> bucket has %d objects and %d contents", bucketName, bucket.objects.len(), len(contents))
+	}
+	bucket.objects.ascend("", func(object bucketObject) bool {
+		content, exists := contents[object.objectKey]
+		if !exists {
+			t.Errorf("<%s> object in <%s> bucket has no content", object.objectKey, bucketName)
+		} else if len(content) != object.contentLength {
+			t.Errorf("<%s> object in <%s> bucket has %d bytes, its record says %d", object.objectKey, bucketName, len(content), object.contentLength)
+		}
+		return true
+	})
+}
+
+// Every worker puts, reads back and deletes its own keys while all of them fight over a shared key
+func TestParallelObjectsInSameBucket(t *testing.T) {
+	router := newTestRouter(t)
+	expectStatus(t, doRequest(router, http.MethodPut, "/shared-bucket", ""), "PUT bucket", http.StatusOK)
+
+	const workers, iterations = 8, 50
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for iteration := 0; iteration < iterations; iteration++ {
+				target := fmt.Sprintf("/shared-bucket/worker-%d/key-%d", worker, iteration%5)
+				content := fmt.Sprintf("content of worker %d at iteration %d", worker, iteration)
+				expectStatus(t, doRequest(router, http.MethodPut, target, content), "PUT "+target, http.StatusOK)
+
+				response := doRequest(router, http.MethodGet, target, "")
+				expectStatus(t, response, "GET "+target, http.StatusOK)
+				if body := responseBody(t, response); body != content {
+					t.Errorf("GET %s: got %q, expected %q", target, body, content)
+				}
+				expectStatus(t, doRequest(router, http.MethodDelete, target, ""), "DELETE "+target, http.StatusNoContent)
+
+				shared := fmt.Sprintf("shared content of worker %d", worker)
+				expectStatus(t, doRequest(router, http.MethodPut, "/shared-bucket/shared", shared), "PUT shared", http.StatusOK)
+				response = doRequest(router, http.MethodGet, "/shared-bucket/shared", "")
+				expectStatus(t, response, "GET shared", http.StatusOK, http.StatusNotFound)
+				if body := responseBody(t, response); response.StatusCode == http.StatusOK && !strings.HasPrefix(body, "shared content of worker") {
+					t.Errorf("GET shared: got mixed content %q", body)
+				}
+				if iteration%10 == 0 {
+					expectStatus(t, doRequest(router, http.MethodDelete, "/shared-bucket/shared", ""), "DELETE shared", http.StatusNoContent, http.StatusNotFound)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	// Only the shared key may be left
+	response := doRequest(router, http.MethodGet, "/shared-bucket?list-type=2", "")
+	expectStatus(t, response, "GET bucket", http.StatusOK)
+	if body := responseBody(t, response); strings.Contains(body, "worker-") {
+		t.Errorf("deleted keys are still listed: %s", body)
+	}
+	checkBucketContents(t, "shared-bucket")
+}
+
+// Workers of different buckets run in parallel with the listings of the buckets and of their objects
+func TestParallelObjectsAcrossBuckets(t *testing.T) {
+	router := newTestRouter(t)
+	const buckets, workersPerBucket, iterations = 4, 4, 30
+	for bucketIdx := 0; bucketIdx < buckets; bucketIdx++ {
+		target := fmt.Sprintf("/bucket-%d", bucketIdx)
+		expectStatus(t, doRequest(router, http.MethodPut, target, ""), "PUT "+target, http.StatusOK)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	var listers sync.WaitGroup
+	listers.Add(1)
+	go func() {
+		defer listers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			expectStatus(t, doRequest(router, http.MethodGet, "/", ""), "GET /", http.StatusOK)
+			for bucketIdx := 0; bucketIdx < buckets; bucketIdx++ {
+				target := fmt.Sprintf("/bucket-%d", bucketIdx)
+				expectStatus(t, doRequest(router, http.MethodGet, target, ""), "GET "+target, http.StatusOK)
+			}
+		}
+	}()
+
+	for bucketIdx := 0; bucketIdx < buckets; bucketIdx++ {
+		for worker := 0; worker < workersPerBucket; worker++ {
+			wg.Add(1)
+			go func(bucketIdx, worker int) {
+				defer wg.Done()
+				for iteration := 0; iteration < iterations; iteration++ {
+					target := fmt.Sprintf("/bucket-%d/worker-%d/key-%d", bucketIdx, worker, iteration)
+					content := strings.Repeat(fmt.Sprintf("%d-%d-%d;", bucketIdx, worker, iteration), iteration+1)
+					expectStatus(t, doRequest(router, http.MethodPut, target, content), "PUT "+target, http.StatusOK)
+
+					response := doRequest(router, http.MethodGet, target, "")
+					expectStatus(t, response, "GET "+target, http.StatusOK)
+					if body := responseBody(t, response); body != content {
+						t.Errorf("GET %s: got %q, expected %q", target, body, content)
+					}
+					// Every other key is kept
+					if iteration%2 == 1 {
+						expectStatus(t, doRequest(router, http.MethodDelete, target, ""), "DELETE "+target, http.StatusNoContent)
+					}
+				}
+			}(bucketIdx, worker)
+		}
+	}
+	wg.Wait()
+	close(done)
+	listers.Wait()
+
+	for bucketIdx := 0; bucketIdx < buckets; bucketIdx++ {
+		bucketName := fmt.Sprintf("bucket-%d", bucketIdx)
+		checkBucketContents(t, bucketName)
+		bucket, _ := store.get(bucketName)
+		if kept := workersPerBucket * iterations / 2; bucket.objects.len() != kept {
+			t.Errorf("<%s> bucket has %d objects, expected %d", bucketName, bucket.objects.len(), kept)
+		}
+		if status, _ := bucket.stats(); status != "active" {
+			t.Errorf("<%s> bucket status is %s, expected active", bucketName, status)
+		}
+	}
+}
+
+// Deleting a bucket while objects are written to it either fails as the bucket is not empty
+// or leaves nothing behind, the writes after it fail as the bucket does not exist
+func TestParallelBucketDeleteAndWrites(t *testing.T) {
+	router := newTestRouter(t)
+	for round := 0; round < 20; round++ {
+		expectStatus(t, doRequest(router, http.MethodPut, "/racy-bucket", ""), "PUT bucket", http.StatusOK)
+
+		var wg sync.WaitGroup
+		for worker := 0; worker < 4; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				target := fmt.Sprintf("/racy-bucket/key-%d", worker)
+				expectStatus(t, doRequest(router, http.MethodPut, target, "content"), "PUT "+target, http.StatusOK, http.StatusNotFound)
+				expectStatus(t, doRequest(router, http.MethodDelete, target, ""), "DELETE "+target, http.StatusNoContent, http.StatusNotFound)
+			}(worker)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			expectStatus(t, doRequest(router, http.MethodDelete, "/racy-bucket", ""), "DELETE bucket", http.StatusNoContent, http.StatusConflict)
+		}()
+		wg.Wait()
+
+		if _, exists := store.get("racy-bucket"); exists {
+			checkBucketContents(t, "racy-bucket")
+			expectStatus(t, doRequest(router, http.MethodDelete, "/racy-bucket", ""), "DELETE bucket", http.StatusNoContent)
+		}
+		memory := backend.(*memoryBackend)
+		memory.mu.Lock()
+		_, leftover := memory.buckets["racy-bucket"]
+		memory.mu.Unlock()
+		if leftover {
+			t.Fatalf("round %d: deleted bucket is left in the backend", round)
+		}
+	}
+}
+
+// failingWriter fails to commit the written content
+type failingWriter struct {
+	ObjectWriter
+}
+
+func (failingWriter) Commit() error {
+	return errors.New("no space left on device")
+}
+
+// A put failing to commit its content leaves the replaced version current and its content readable
+func TestFailedCommitRestoresReplacedVersion(t *testing.T) {
+	router := newTestRouter(t)
+	expectStatus(t, doRequest(router, http.MethodPut, "/versioned-bucket", ""), "PUT bucket", http.StatusOK)
+	expectStatus(t, doRequest(router, http.MethodPut, "/versioned-bucket?versioning", "<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>"), "PUT versioning", http.StatusOK)
+	expectStatus(t, doRequest(router, http.MethodPut, "/versioned-bucket/key", "first"), "PUT key", http.StatusOK)
+
+	bucket, err := store.lockBucket("versioned-bucket")
+	if err != nil {
+		t.Fatalf("<versioned-bucket> bucket: %s", err)
+	}
+	objectWriter, err := backend.CreateObject(bucket.Name, "key")
+	if err == nil {
+		object := bucketObject{objectKey: "key", contentLength: len("second")}
+		err = commitObject(bucket, failingWriter{objectWriter}, &object)
+	}
+	bucket.mu.Unlock()
+	if err == nil {
+		t.Fatal("commit of the failing writer succeeded")
+	}
+
+	response := doRequest(router, http.MethodGet, "/versioned-bucket/key", "")
+	expectStatus(t, response, "GET key", http.StatusOK)
+	if body := responseBody(t, response); body != "first" {
+		t.Errorf("GET key: got %q, expected %q", body, "first")
+	}
+	if versions := len(bucket.versions["key"]); versions != 0 {
+		t.Errorf("replaced object left %d noncurrent versions", versions)
+	}
+	checkBucketContents(t, "versioned-bucket")
+}
+
+// A completion failing to commit the assembled object leaves the upload and its parts for a retry
+func TestFailedMultipartCompletionCanBeRetried(t *testing.T) {
+	router := newTestRouter(t)
+	expectStatus(t, doRequest(router, http.MethodPut, "/upload-bucket", ""), "PUT bucket", http.StatusOK)
+	response := doRequest(router, http.MethodPost, "/upload-bucket/key?uploads", "")
+	expectStatus(t, response, "POST uploads", http.StatusOK)
+	body := responseBody(t, response)
+	uploadID := body[strings.Index(body, "<UploadId>")+len("<UploadId>") : strings.Index(body, "</UploadId>")]
+
+	response = doRequest(router, http.MethodPut, "/upload-bucket/key?partNumber=1&uploadId="+uploadID, "part content")
+	expectStatus(t, response, "PUT part", http.StatusOK)
+	completion := fmt.Sprintf("<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>", response.Header.Get("ETag"))
+
+	// Contents of the bucket cannot be written while it is missing from the backend
+	memory := backend.(*memoryBackend)
+	memory.mu.Lock()
+	stored := memory.buckets["upload-bucket"]
+	delete(memory.buckets, "upload-bucket")
+	memory.mu.Unlock()
+	response = doRequest(router, http.MethodPost, "/upload-bucket/key?uploadId="+uploadID, completion)
+	if response.StatusCode == http.StatusOK {
+		t.Fatal("completion succeeded without the bucket contents")
+	}
+	memory.mu.Lock()
+	memory.buckets["upload-bucket"] = stored
+	memory.mu.Unlock()
+
+	expectStatus(t, doRequest(router, http.MethodPost, "/upload-bucket/key?uploadId="+uploadID, completion), "POST retried completion", http.StatusOK)
+	response = doRequest(router, http.MethodGet, "/upload-bucket/key", "")
+	expectStatus(t, response, "GET key", http.StatusOK)
+	if body := responseBody(t, response); body != "part content" {
+		t.Errorf("GET key: got %q, expected %q", body, "part content")
+	}
+}
@@ -0,0 +1,205 @@
+package web
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Errors
+var (
+	ErrTooManyTags          = errors.New("object tags cannot be greater than 10")
+	ErrInvalidTagKey        = errors.New("the tag key you have provided is invalid")
+	ErrInvalidTagValue      = errors.New("the tag value you have provided is invalid")
+	ErrDuplicateTagKey      = errors.New("cannot provide multiple tags with the same key")
+	ErrInvalidTaggingHeader = errors.New("the x-amz-tagging header must be a URL query of tags")
+	ErrInvalidTagDirective  = errors.New("unknown tagging directive")
+)
+
+// Limits of the object tags
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// Prefix of the tag keys reserved for the service
+const reservedTagKeyPrefix = "aws:"
+
+type objectTag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type tagging struct {
+	XMLName xml.Name    `xml:"Tagging"`
+	TagSet  []objectTag `xml:"TagSet>Tag"`
+}
+
+// Reports whether the tag key or value has only letters, digits, spaces and + - = . _ : / @
+func validTagCharacters(value string) bool {
+	for _, char := range value {
+		if !unicode.IsLetter(char) && !unicode.IsDigit(char) && !unicode.IsSpace(char) && !strings.ContainsRune("+-=._:/@", char) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validates the tags against the rules of S3 and returns them as a map, nil when there are none
+func validateTags(tags []objectTag) (map[string]string, error) {
+	if len(tags) > maxObjectTags {
+		return nil, ErrTooManyTags
+	} else if len(tags) == 0 {
+		return nil, nil
+	}
+	tagMap := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		keyLength := utf8.RuneCountInString(tag.Key)
+		if keyLength == 0 || keyLength > maxTagKeyLength || !validTagCharacters(tag.Key) || strings.HasPrefix(tag.Key, reservedTagKeyPrefix) {
+			return nil, ErrInvalidTagKey
+		}
+		if utf8.RuneCountInString(tag.Value) > maxTagValueLength || !validTagCharacters(tag.Value) {
+			return nil, ErrInvalidTagValue
+		}
+		if _, exists := tagMap[tag.Key]; exists {
+			return nil, ErrDuplicateTagKey
+		}
+		tagMap[tag.Key] = tag.Value
+	}
+	return tagMap, nil
+}
+
+// Parses the tags of the x-amz-tagging header, they are given in URL query form
+func tagsFromRequest(r *http.Request) (map[string]string, error) {
+	header := r.Header.Get("X-Amz-Tagging")
+	if header == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, ErrInvalidTaggingHeader
+	}
+	tags := []objectTag{}
+	for key, keyValues := range values {
+		if len(keyValues) > 1 {
+			return nil, ErrDuplicateTagKey
+		}
+		tags = append(tags, objectTag{Key: key, Value: keyValues[0]})
+	}
+	return validateTags(tags)
+}
+
+// Tags are kept in a single csv field in URL query form
+func encodeObjectTags(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+func decodeObjectTags(field string) (map[string]string, error) {
+	if field == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(field)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(values))
+	for key := range values {
+		tags[key] = values.Get(key)
+	}
+	return tags, nil
+}
+
+// GET /<bucket>/<key>?tagging handler
+func getObjectTagging(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	object, err := lookupObject(bucketName, objectName, r.URL.Query().Get("versionId"))
+	if err != nil {
+		return err
+	}
+	result := tagging{TagSet: []objectTag{}}
+	for _, key := range sortedKeys(object.tags) {
+		result.TagSet = append(result.TagSet, objectTag{Key: key, Value: object.tags[key]})
+	}
+	marshalledObject, err := xml.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the tags of <%s> object: %w", objectName, err)
+	}
+	if object.versionID != "" {
+		w.Header().Set("X-Amz-Version-Id", object.versionID)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+// PUT /<bucket>/<key>?tagging handler, the tag set replaces the tags of the object
+func putObjectTagging(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	request := tagging{}
+	err := xml.NewDecoder(io.LimitReader(r.Body, bytesIn1mb)).Decode(&request)
+	if err != nil {
+		return ErrMalformedXML
+	}
+	tags, err := validateTags(request.TagSet)
+	if err != nil {
+		return err
+	}
+	return setObjectTags(w, r, bucketName, objectName, tags)
+}
+
+// DELETE /<bucket>/<key>?tagging handler
+func deleteObjectTagging(w http.ResponseWriter, r *http.Request, bucketName, objectName string) error {
+	return setObjectTags(w, r, bucketName, objectName, nil)
+}
+
+// Replaces the tags of the requested version of the object and persists its record
+func setObjectTags(w http.ResponseWriter, r *http.Request, bucketName, objectName string, tags map[string]string) error {
+	bucket, err := store.lockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.mu.Unlock()
+
+	object, current, err := findObjectVersion(bucket, objectName, r.URL.Query().Get("versionId"))
+	if err != nil {
+		return err
+	}
+	object.tags = tags
+	if current {
+		err = setObjectRecord(bucket, object)
+	} else {
+		// Noncurrent version keeps its place in the list, its record is replaced
+		list := bucket.versions[objectName]
+		idx, _ := findVersion(list, object.versionID)
+		list[idx] = object
+		err = backend.SaveVersions(bucketName, bucket.versions, []objectChange{{object: object}})
+	}
+	if err != nil {
+		return fmt.Errorf("error while saving tags of <%s> object in <%s> bucket: %w", objectName, bucketName, err)
+	}
+	if object.versionID != "" {
+		w.Header().Set("X-Amz-Version-Id", object.versionID)
+	}
+	log.Printf("tags of <%s> object in <%s> bucket updated", objectName, bucketName)
+	return nil
+}
+
+// Returns the keys of the map in ascending order
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,516 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrNoSuchVersion           = errors.New("the specified version does not exist")
+	ErrInvalidVersionID        = errors.New("invalid version id specified")
+	ErrVersionIsDeleteMarker   = errors.New("the specified version is a delete marker")
+	ErrInvalidVersioningStatus = errors.New("versioning status must be Enabled or Suspended")
+)
+
+// Versioning statuses of a bucket
+const (
+	versioningEnabled   = "Enabled"
+	versioningSuspended = "Suspended"
+)
+
+// Version ID of the objects written while versioning is not enabled, it is stored as an empty version ID
+const nullVersionID = "null"
+
+// Generated version IDs are 32 hex digits
+var versionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// Returns a new version ID, the nanosecond time followed by random bytes
+func newVersionID() string {
+	id := make([]byte, 16)
+	binary.BigEndian.PutUint64(id, uint64(time.Now().UnixNano()))
+	rand.Read(id[8:])
+	return hex.EncodeToString(id)
+}
+
+// Version ID as it is shown to the clients
+func displayVersionID(versionID string) string {
+	if versionID == "" {
+		return nullVersionID
+	}
+	return versionID
+}
+
+// Parses the version ID given by the client, null is the empty version ID
+func parseVersionID(value string) (string, error) {
+	if value == nullVersionID {
+		return "", nil
+	} else if !versionIDPattern.MatchString(value) {
+		return "", ErrInvalidVersionID
+	}
+	return value, nil
+}
+
+// Returns the versioning status of the bucket
+func bucketVersioning(bucket *bucketData) string {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return bucket.versioning
+}
+
+// Sets the version headers of the response describing the version
+func writeVersionHeaders(w http.ResponseWriter, versioning string, version bucketObject) {
+	if versioning == "" && version.versionID == "" {
+		return
+	}
+	w.Header().Set("X-Amz-Version-Id", displayVersionID(version.versionID))
+	if version.deleteMarker {
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+	}
+}
+
+// Returns the index of the version in the list
+func findVersion(list []bucketObject, versionID string) (int, bool) {
+	for idx, version := range list {
+		if version.versionID == versionID {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// Drops the version from the lists
+func removeVersionFromList(versions map[string][]bucketObject, objectKey, versionID string) {
+	list := versions[objectKey]
+	idx, exists := findVersion(list, versionID)
+	if !exists {
+		return
+	}
+	list = append(list[:idx:idx], list[idx+1:]...)
+	if len(list) == 0 {
+		delete(versions, objectKey)
+	} else {
+		versions[objectKey] = list
+	}
+}
+
+// Total number of the noncurrent versions
+func countVersions(versions map[string][]bucketObject) int {
+	count := 0
+	for _, list := range versions {
+		count += len(list)
+	}
+	return count
+}
+
+// Returns the requested version of the object, the current one when no version is requested,
+// the caller must hold the bucket lock. Reports whether the version is the current one
+func findObjectVersion(bucket *bucketData, objectKey, requested string) (bucketObject, bool, error) {
+	current, exists := bucket.objects.find(objectKey)
+	if requested == "" {
+		if !exists {
+			return bucketObject{}, false, ErrObjectNotExists
+		}
+		return current, true, nil
+	}
+	versionID, err := parseVersionID(requested)
+	if err != nil {
+		return bucketObject{}, false, err
+	}
+	if exists && current.versionID == versionID {
+		return current, true, nil
+	}
+	list := bucket.versions[objectKey]
+	idx, exists := findVersion(list, versionID)
+	if !exists {
+		return bucketObject{}, false, ErrNoSuchVersion
+	} else if list[idx].deleteMarker {
+		return list[idx], false, ErrVersionIsDeleteMarker
+	}
+	return list[idx], false, nil
+}
+
+// Journal entries which set the current object aside before a new version of the key takes its place.
+// Unversioned buckets keep no history and a suspended bucket replaces the null version
+func replaceCurrentEntries(bucket *bucketData, versioning, objectKey string) []journalEntry {
+	if versioning == "" {
+		return nil
+	}
+	entries := []journalEntry{}
+	current, exists := bucket.objects.find(objectKey)
+	if exists && (versioning == versioningEnabled || current.versionID != "") {
+		entries = append(entries, versionEntry(journalArchiveObject, bucket.Name, current))
+	}
+	if versioning == versioningSuspended {
+		list := bucket.versions[objectKey]
+		if idx, exists := findVersion(list, ""); exists {
+			entries = append(entries, versionEntry(journalRemoveVersion, bucket.Name, list[idx]))
+		}
+	}
+	return entries
+}
+
+// Applies the version entries to the bucket and persists them, the caller must hold the bucket lock for writing.
+// Applying an entry again does nothing, so the journal recovery repeats the entries of unfinished transactions
+func applyVersionEntries(bucket *bucketData, entries []journalEntry) error {
+	objectChanges, versionChanges := []objectChange{}, []objectChange{}
+	for _, entry := range entries {
+		version := entry.object
+		objectKey := version.objectKey
+		list := bucket.versions[objectKey]
+		_, listed := findVersion(list, version.versionID)
+		switch entry.operation {
+		case journalArchiveObject:
+			if listed {
+				continue
+			}
+			if !version.deleteMarker {
+				err := backend.ArchiveObject(bucket.Name, objectKey, version.versionID)
+				if err != nil {
+					return fmt.Errorf("error while keeping <%s> version of <%s> object: %w", displayVersionID(version.versionID), objectKey, err)
+				}
+			}
+			bucket.versions[objectKey] = append([]bucketObject{version}, list...)
+			versionChanges = append(versionChanges, objectChange{object: version})
+
+		case journalRemoveVersion:
+			if !version.deleteMarker {
+				err := backend.RemoveVersion(bucket.Name, objectKey, version.versionID)
+				if err != nil {
+					return fmt.Errorf("error while removing <%s> version of <%s> object: %w", displayVersionID(version.versionID), objectKey, err)
+				}
+			}
+			if listed {
+				removeVersionFromList(bucket.versions, objectKey, version.versionID)
+				versionChanges = append(versionChanges, objectChange{removed: true, object: version})
+			}
+
+		case journalRestoreObject:
+			if !listed {
+				continue
+			}
+			err := backend.RestoreObject(bucket.Name, objectKey, version.versionID)
+			if err != nil {
+				return fmt.Errorf("error while restoring <%s> version of <%s> object: %w", displayVersionID(version.versionID), objectKey, err)
+			}
+			removeVersionFromList(bucket.versions, objectKey, version.versionID)
+			versionChanges = append(versionChanges, objectChange{removed: true, object: version})
+			bucket.objects.set(version)
+			objectChanges = append(objectChanges, objectChange{object: version})
+		}
+	}
+
+	// Restored objects are persisted first, a crash in between leaves them listed as noncurrent too
+	// and the recovery repeats the restore
+	if len(objectChanges) > 0 {
+		err := saveObjectsData(bucket, objectChanges...)
+		if err != nil {
+			return fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucket.Name, err)
+		}
+	}
+	if len(versionChanges) > 0 {
+		err := backend.SaveVersions(bucket.Name, bucket.versions, versionChanges)
+		if err != nil {
+			return fmt.Errorf("error while saving versions metadata in <%s> bucket: %w", bucket.Name, err)
+		}
+	}
+	return nil
+}
+
+// Deletes the key of the versioned bucket placing a delete marker, or removes the requested version
+// for good in any bucket. The caller must hold the bucket lock for writing.
+// Returns the placed delete marker or the removed version
+func deleteVersion(bucket *bucketData, versioning, objectKey, requested string) (bucketObject, error) {
+	current, exists := bucket.objects.find(objectKey)
+	if requested == "" {
+		// Delete marker becomes the latest version
+		marker := bucketObject{objectKey: objectKey, lastModified: time.Now().UTC().Format(time.RFC3339), deleteMarker: true}
+		if versioning == versioningEnabled {
+			marker.versionID = newVersionID()
+		}
+		entries := replaceCurrentEntries(bucket, versioning, objectKey)
+		entries = append(entries, versionEntry(journalArchiveObject, bucket.Name, marker))
+		if exists {
+			entries = append(entries, versionEntry(journalDeleteObject, bucket.Name, current))
+		}
+		return marker, applyDeleteEntries(bucket, entries)
+	}
+
+	versionID, err := parseVersionID(requested)
+	if err != nil {
+		return bucketObject{}, err
+	}
+	var removed bucketObject
+	entries := []journalEntry{}
+	remaining := bucket.versions[objectKey]
+	if exists && current.versionID == versionID {
+		removed = current
+		entries = append(entries, versionEntry(journalDeleteObject, bucket.Name, current))
+	} else {
+		idx, listed := findVersion(remaining, versionID)
+		if !listed {
+			return bucketObject{}, ErrNoSuchVersion
+		}
+		removed = remaining[idx]
+		entries = append(entries, versionEntry(journalRemoveVersion, bucket.Name, removed))
+		remaining = append(remaining[:idx:idx], remaining[idx+1:]...)
+	}
+	// Newest noncurrent version takes the place of the removed latest version unless it is a delete marker
+	if !exists || current.versionID == versionID {
+		if len(remaining) > 0 && !remaining[0].deleteMarker {
+			entries = append(entries, versionEntry(journalRestoreObject, bucket.Name, remaining[0]))
+		}
+	}
+	return removed, applyDeleteEntries(bucket, entries)
+}
+
+// Applies the entries of a delete through the journal, the caller must hold the bucket lock for writing
+func applyDeleteEntries(bucket *bucketData, entries []journalEntry) error {
+	transactionID, err := journal.begin(entries...)
+	if err != nil {
+		return err
+	}
+	defer journal.abort(transactionID)
+	for idx, entry := range entries {
+		if entry.operation != journalDeleteObject {
+			continue
+		}
+		// Versions are set aside before the current object is removed and restored after it
+		err = applyVersionEntries(bucket, entries[:idx])
+		if err != nil {
+			return err
+		}
+		err = removeObject(bucket, entry.object.objectKey)
+		if err != nil {
+			return err
+		}
+		err = saveObjectsData(bucket, removedObjectChange(entry.object.objectKey))
+		if err != nil {
+			return fmt.Errorf("error while saving objects metadata in <%s> bucket: %w", bucket.Name, err)
+		}
+		entries = entries[idx+1:]
+		break
+	}
+	err = applyVersionEntries(bucket, entries)
+	if err != nil {
+		return err
+	}
+	journal.end(transactionID)
+	return nil
+}
+
+// GET /<bucket>?versioning handler
+func getBucketVersioning(w http.ResponseWriter, bucketName string) error {
+	bucket, exists := store.get(bucketName)
+	if !exists {
+		return ErrBucketNotExists
+	}
+	marshalledObject, err := xml.MarshalIndent(versioningConfiguration{Status: bucketVersioning(bucket)}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the versioning of <%s> bucket: %w", bucketName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+// PUT /<bucket>?versioning handler, once enabled versioning can only be suspended
+func putBucketVersioning(r *http.Request, bucketName string) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, bytesIn1mb))
+	if err != nil {
+		return fmt.Errorf("error while reading the versioning configuration of <%s> bucket: %w", bucketName, err)
+	}
+	err = checkContentMD5(r, body)
+	if err != nil {
+		return err
+	}
+	configuration := versioningConfiguration{}
+	err = xml.Unmarshal(body, &configuration)
+	if err != nil {
+		return ErrMalformedXML
+	} else if configuration.Status != versioningEnabled && configuration.Status != versioningSuspended {
+		return ErrInvalidVersioningStatus
+	}
+
+	err = setBucketConfig(bucketName, func(bucket *bucketData) func() {
+		previous := bucket.versioning
+		bucket.versioning = configuration.Status
+		return func() {
+			bucket.versioning = previous
+		}
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("versioning of <%s> bucket is %s", bucketName, strings.ToLower(configuration.Status))
+	return nil
+}
+
+type listVersionsResult struct {
+	XMLName             xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListVersionsResult"`
+	Name                string          `xml:"Name"`
+	Prefix              string          `xml:"Prefix"`
+	KeyMarker           string          `xml:"KeyMarker"`
+	VersionIDMarker     string          `xml:"VersionIdMarker"`
+	NextKeyMarker       string          `xml:"NextKeyMarker,omitempty"`
+	NextVersionIDMarker string          `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int             `xml:"MaxKeys"`
+	IsTruncated         bool            `xml:"IsTruncated"`
+	Versions            []listedVersion `xml:"Version"`
+}
+
+// listedVersion is a Version or a DeleteMarker element, they are listed interleaved in key order
+type listedVersion struct {
+	deleteMarker bool
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         *int   `xml:"Size,omitempty"`
+	StorageClass string `xml:"StorageClass,omitempty"`
+}
+
+// Fields of listedVersion without its element naming
+type plainListedVersion listedVersion
+
+func (v listedVersion) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "Version"}
+	if v.deleteMarker {
+		start.Name.Local = "DeleteMarker"
+	}
+	return e.EncodeElement(plainListedVersion(v), start)
+}
+
+// GET /<bucket>?versions handler, versions are listed by key and newest first
+func listObjectVersions(w http.ResponseWriter, r *http.Request, bucketName string) error {
+	query := r.URL.Query()
+	result := listVersionsResult{
+		Name:            bucketName,
+		Prefix:          query.Get("prefix"),
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: query.Get("version-id-marker"),
+		MaxKeys:         maxListKeys,
+	}
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			return ErrInvalidMaxKeys
+		}
+		result.MaxKeys = min(maxKeys, maxListKeys)
+	}
+	versionIDMarker := ""
+	if result.VersionIDMarker != "" {
+		if result.KeyMarker == "" {
+			return ErrInvalidVersionID
+		}
+		var err error
+		versionIDMarker, err = parseVersionID(result.VersionIDMarker)
+		if err != nil {
+			return err
+		}
+	}
+
+	bucket, err := store.rlockBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.mu.RUnlock()
+
+	// Keys with noncurrent versions are merged with the keys of the index
+	from := max(result.Prefix, result.KeyMarker)
+	versionKeys := []string{}
+	for objectKey := range bucket.versions {
+		if objectKey >= from && strings.HasPrefix(objectKey, result.Prefix) {
+			versionKeys = append(versionKeys, objectKey)
+		}
+	}
+	sort.Strings(versionKeys)
+
+	// Adds the versions of the key past the markers, reports whether the listing goes on
+	listKey := func(objectKey string, current *bucketObject) bool {
+		versions := bucket.versions[objectKey]
+		if current != nil {
+			versions = append([]bucketObject{*current}, versions...)
+		}
+		skip := objectKey == result.KeyMarker
+		for idx, version := range versions {
+			if skip {
+				// Listing resumes after the version marker, a key marker alone skips the whole key
+				skip = result.VersionIDMarker == "" || version.versionID != versionIDMarker
+				continue
+			}
+			if len(result.Versions) == result.MaxKeys {
+				result.IsTruncated = true
+				return false
+			}
+			result.Versions = append(result.Versions, newListedVersion(version, idx == 0))
+			result.NextKeyMarker, result.NextVersionIDMarker = objectKey, displayVersionID(version.versionID)
+		}
+		return true
+	}
+	bucket.objects.ascend(from, func(object bucketObject) bool {
+		if !strings.HasPrefix(object.objectKey, result.Prefix) {
+			return false
+		}
+		for len(versionKeys) > 0 && versionKeys[0] < object.objectKey {
+			if !listKey(versionKeys[0], nil) {
+				return false
+			}
+			versionKeys = versionKeys[1:]
+		}
+		if len(versionKeys) > 0 && versionKeys[0] == object.objectKey {
+			versionKeys = versionKeys[1:]
+		}
+		return listKey(object.objectKey, &object)
+	})
+	for len(versionKeys) > 0 && !result.IsTruncated {
+		listKey(versionKeys[0], nil)
+		versionKeys = versionKeys[1:]
+	}
+	if !result.IsTruncated {
+		result.NextKeyMarker, result.NextVersionIDMarker = "", ""
+	}
+
+	marshalledObject, err := xml.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the versions of <%s> bucket: %w", bucketName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	log.Printf("%d versions of <%s> bucket listed", len(result.Versions), bucketName)
+	return nil
+}
+
+func newListedVersion(version bucketObject, isLatest bool) listedVersion {
+	listed := listedVersion{
+		deleteMarker: version.deleteMarker,
+		Key:          version.objectKey,
+		VersionID:    displayVersionID(version.versionID),
+		IsLatest:     isLatest,
+		LastModified: formatListTime(version.lastModified),
+	}
+	if version.deleteMarker {
+		return listed
+	}
+	size := version.contentLength
+	listed.ETag = quoteETag(version.etag)
+	listed.Size = &size
+	listed.StorageClass = "STANDARD"
+	return listed
+}
@@ -0,0 +1,408 @@
+package web
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Errors
+var (
+	ErrNoSuchWebsiteConfiguration = errors.New("the specified bucket does not have a website configuration")
+	ErrInvalidIndexDocument       = errors.New("index document suffix must not be empty and must not contain a slash")
+	ErrInvalidRedirectProtocol    = errors.New("redirect protocol must be http or https")
+	ErrInvalidRedirectCode        = errors.New("redirect code must be a 3XX status code")
+	ErrInvalidRedirectKey         = errors.New("redirect cannot replace both the key and the key prefix")
+	ErrInvalidErrorCodeCondition  = errors.New("error code condition must be a 4XX or 5XX status code")
+)
+
+// Maximum number of routing rules in one website configuration
+const maxRoutingRules = 50
+
+type websiteConfiguration struct {
+	XMLName xml.Name `xml:"WebsiteConfiguration"`
+	// Redirects every request of the website to another host, no other element is allowed with it
+	RedirectAllRequestsTo *redirectAllRequestsTo `xml:"RedirectAllRequestsTo"`
+	IndexDocument         *indexDocument         `xml:"IndexDocument"`
+	ErrorDocument         *errorDocument         `xml:"ErrorDocument"`
+	RoutingRules          []routingRule          `xml:"RoutingRules>RoutingRule"`
+}
+
+type redirectAllRequestsTo struct {
+	HostName string `xml:"HostName"`
+	Protocol string `xml:"Protocol,omitempty"`
+}
+
+// Suffix appended to the directory-style paths, e.g. index.html
+type indexDocument struct {
+	Suffix string `xml:"Suffix"`
+}
+
+// Key of the object served along with the 4XX errors
+type errorDocument struct {
+	Key string `xml:"Key"`
+}
+
+type routingRule struct {
+	Condition *routingCondition `xml:"Condition"`
+	Redirect  websiteRedirect   `xml:"Redirect"`
+}
+
+// Rule without a condition redirects every request, the error code makes it apply only to the failed ones
+type routingCondition struct {
+	KeyPrefixEquals             string `xml:"KeyPrefixEquals,omitempty"`
+	HttpErrorCodeReturnedEquals int    `xml:"HttpErrorCodeReturnedEquals,omitempty"`
+}
+
+// Unset fields keep the protocol, the host and the key of the request, the code defaults to 301
+type websiteRedirect struct {
+	Protocol             string  `xml:"Protocol,omitempty"`
+	HostName             string  `xml:"HostName,omitempty"`
+	ReplaceKeyPrefixWith *string `xml:"ReplaceKeyPrefixWith"`
+	ReplaceKeyWith       *string `xml:"ReplaceKeyWith"`
+	HttpRedirectCode     int     `xml:"HttpRedirectCode,omitempty"`
+}
+
+// Parses and validates the website configuration
+func parseWebsite(data []byte) (*websiteConfiguration, error) {
+	website := &websiteConfiguration{}
+	err := xml.Unmarshal(data, website)
+	if err != nil || len(website.RoutingRules) > maxRoutingRules {
+		return nil, ErrMalformedXML
+	}
+
+	if target := website.RedirectAllRequestsTo; target != nil {
+		if website.IndexDocument != nil || website.ErrorDocument != nil || len(website.RoutingRules) > 0 || target.HostName == "" {
+			return nil, ErrMalformedXML
+		}
+		return website, validRedirectProtocol(target.Protocol)
+	}
+
+	if website.IndexDocument == nil {
+		return nil, ErrMalformedXML
+	} else if suffix := website.IndexDocument.Suffix; suffix == "" || strings.Contains(suffix, "/") {
+		return nil, ErrInvalidIndexDocument
+	}
+	if website.ErrorDocument != nil && website.ErrorDocument.Key == "" {
+		return nil, ErrMalformedXML
+	}
+	for _, rule := range website.RoutingRules {
+		redirect := rule.Redirect
+		if err := validRedirectProtocol(redirect.Protocol); err != nil {
+			return nil, err
+		}
+		if redirect.ReplaceKeyPrefixWith != nil && redirect.ReplaceKeyWith != nil {
+			return nil, ErrInvalidRedirectKey
+		}
+		if code := redirect.HttpRedirectCode; code != 0 && (code < 300 || code > 399) {
+			return nil, ErrInvalidRedirectCode
+		}
+		if rule.Condition != nil {
+			if code := rule.Condition.HttpErrorCodeReturnedEquals; code != 0 && (code < 400 || code > 599) {
+				return nil, ErrInvalidErrorCodeCondition
+			}
+		}
+	}
+	return website, nil
+}
+
+func validRedirectProtocol(protocol string) error {
+	if protocol != "" && protocol != "http" && protocol != "https" {
+		return ErrInvalidRedirectProtocol
+	}
+	return nil
+}
+
+// Returns the first routing rule matching the key, before the object is read when the status code
+// is 0 and after it failed with the status code otherwise
+func (website *websiteConfiguration) matchRoutingRule(objectKey string, statusCode int) (routingRule, bool) {
+	for _, rule := range website.RoutingRules {
+		condition := rule.Condition
+		if condition == nil {
+			if statusCode == 0 {
+				return rule, true
+			}
+			continue
+		}
+		if condition.HttpErrorCodeReturnedEquals != statusCode {
+			continue
+		}
+		if strings.HasPrefix(objectKey, condition.KeyPrefixEquals) {
+			return rule, true
+		}
+	}
+	return routingRule{}, false
+}
+
+// Redirects the request for the key as the routing rule says
+func redirectWebsite(w http.ResponseWriter, r *http.Request, rule routingRule, objectKey string) {
+	redirect := rule.Redirect
+	switch {
+	case redirect.ReplaceKeyWith != nil:
+		objectKey = *redirect.ReplaceKeyWith
+	case redirect.ReplaceKeyPrefixWith != nil:
+		prefix := ""
+		if rule.Condition != nil {
+			prefix = rule.Condition.KeyPrefixEquals
+		}
+		objectKey = *redirect.ReplaceKeyPrefixWith + strings.TrimPrefix(objectKey, prefix)
+	}
+	statusCode := redirect.HttpRedirectCode
+	if statusCode == 0 {
+		statusCode = http.StatusMovedPermanently
+	}
+	location := url.URL{Scheme: redirect.Protocol, Host: redirect.HostName, Path: "/" + objectKey}
+	if location.Scheme == "" {
+		location.Scheme = requestProtocol(r)
+	}
+	if location.Host == "" {
+		location.Host = r.Host
+	}
+	http.Redirect(w, r, location.String(), statusCode)
+}
+
+func requestProtocol(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// WebsiteRoutes returns the handler of the static website endpoint
+func WebsiteRoutes() http.Handler {
+	return http.HandlerFunc(websiteHandler)
+}
+
+// Website handler, serves the objects of the bucket selected by the Host header to anonymous readers.
+// Directory-style paths are served their index document and the errors are HTML pages
+func websiteHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("website %s request with URL: %s", r.Method, r.URL.String())
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		respondErrorHTML(w, r, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return
+	}
+
+	bucketName := websiteBucketName(r.Host)
+	website, err := bucketWebsite(bucketName)
+	if err != nil {
+		respondErrorHTML(w, r, http.StatusNotFound, err)
+		return
+	} else if website == nil {
+		respondErrorHTML(w, r, http.StatusNotFound, ErrNoSuchWebsiteConfiguration)
+		return
+	}
+
+	if target := website.RedirectAllRequestsTo; target != nil {
+		location := url.URL{Scheme: target.Protocol, Host: target.HostName, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		if location.Scheme == "" {
+			location.Scheme = requestProtocol(r)
+		}
+		http.Redirect(w, r, location.String(), http.StatusMovedPermanently)
+		return
+	}
+
+	objectKey := strings.TrimPrefix(r.URL.Path, "/")
+	if rule, matched := website.matchRoutingRule(objectKey, 0); matched {
+		redirectWebsite(w, r, rule, objectKey)
+		return
+	}
+
+	// Directory-style paths are served their index document
+	indexKey := objectKey
+	if indexKey == "" || strings.HasSuffix(indexKey, "/") {
+		indexKey += website.IndexDocument.Suffix
+	}
+	err = serveWebsiteObject(w, r, bucketName, indexKey, http.StatusOK)
+	if err == nil {
+		return
+	} else if err == ErrNotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Key of a directory without the trailing slash is redirected to the directory
+	if err == ErrObjectNotExists && indexKey == objectKey {
+		directoryKey := objectKey + "/"
+		if _, lookupErr := lookupObject(bucketName, directoryKey+website.IndexDocument.Suffix, ""); lookupErr == nil {
+			http.Redirect(w, r, (&url.URL{Path: "/" + directoryKey}).String(), http.StatusFound)
+			return
+		}
+	}
+
+	statusCode := websiteErrorStatusCode(err)
+	if rule, matched := website.matchRoutingRule(objectKey, statusCode); matched {
+		redirectWebsite(w, r, rule, objectKey)
+		return
+	}
+	if website.ErrorDocument != nil && statusCode >= 400 && statusCode < 500 {
+		if serveWebsiteObject(w, r, bucketName, website.ErrorDocument.Key, statusCode) == nil {
+			return
+		}
+	}
+	respondErrorHTML(w, r, statusCode, err)
+}
+
+// Bucket of the website request: the whole host name when a bucket has it, as with a CNAME record,
+// or its first label, as with <bucket>.<website endpoint>
+func websiteBucketName(host string) string {
+	if hostName, _, err := net.SplitHostPort(host); err == nil {
+		host = hostName
+	}
+	host = strings.ToLower(host)
+	store.mu.RLock()
+	_, exists := store.buckets[host]
+	store.mu.RUnlock()
+	if exists {
+		return host
+	}
+	bucketName, _, _ := strings.Cut(host, ".")
+	return bucketName
+}
+
+// Serves the object to an anonymous reader with the status code, the error document is served with the
+// status code of the error while the other objects keep the conditional and partial requests
+func serveWebsiteObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string, statusCode int) error {
+	err := authorize(r, "s3:GetObject", bucketName, objectKey, "")
+	if err != nil {
+		return err
+	}
+	// Query parameters name no subresources on the website endpoint
+	anonymous := r.Clone(r.Context())
+	anonymous.URL.RawQuery = ""
+
+	if statusCode == http.StatusOK {
+		if r.Method == http.MethodHead {
+			return headObject(w, anonymous, bucketName, objectKey)
+		}
+		return retrieveObject(w, anonymous, bucketName, objectKey)
+	}
+
+	object, objectFile, err := openObject(bucketName, objectKey, "")
+	if err != nil {
+		return err
+	}
+	defer objectFile.Close()
+	writeObjectHeaders(w, object)
+	if object.contentType == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.WriteHeader(statusCode)
+	if r.Method == http.MethodGet {
+		_, err = io.Copy(w, objectFile)
+		if err != nil {
+			log.Printf("error while sending <%s> error document in <%s> bucket: %s", objectKey, bucketName, err)
+		}
+	}
+	return nil
+}
+
+// Status code of the errors returned while serving the website
+func websiteErrorStatusCode(err error) int {
+	switch err {
+	case ErrObjectNotExists, ErrBucketNotExists:
+		return http.StatusNotFound
+	case ErrAccessDenied:
+		return http.StatusForbidden
+	case ErrInvalidRange:
+		return http.StatusRequestedRangeNotSatisfiable
+	case ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Returns the website configuration of the bucket, nil when there is none
+func bucketWebsite(bucketName string) (*websiteConfiguration, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	bucket, exists := store.buckets[bucketName]
+	if !exists {
+		return nil, ErrBucketNotExists
+	}
+	return bucket.website, nil
+}
+
+// GET /<bucket>?website handler
+func getBucketWebsite(w http.ResponseWriter, bucketName string) error {
+	website, err := bucketWebsite(bucketName)
+	if err != nil {
+		return err
+	} else if website == nil {
+		return ErrNoSuchWebsiteConfiguration
+	}
+	marshalledObject, err := xml.MarshalIndent(website, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling the website configuration of <%s> bucket: %w", bucketName, err)
+	}
+	respondSuccessXML(w, marshalledObject)
+	return nil
+}
+
+// PUT /<bucket>?website handler, the configuration replaces the previous one
+func putBucketWebsite(r *http.Request, bucketName string) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, bytesIn1mb))
+	if err != nil {
+		return fmt.Errorf("error while reading the website configuration of <%s> bucket: %w", bucketName, err)
+	}
+	err = checkContentMD5(r, body)
+	if err != nil {
+		return err
+	}
+	website, err := parseWebsite(body)
+	if err != nil {
+		return err
+	}
+	// Configuration is stored in its canonical form
+	websiteXML, err := xml.Marshal(website)
+	if err != nil {
+		return fmt.Errorf("error while marshaling the website configuration of <%s> bucket: %w", bucketName, err)
+	}
+	err = setBucketWebsite(bucketName, string(websiteXML), website)
+	if err != nil {
+		return err
+	}
+	log.Printf("website configuration of <%s> bucket updated", bucketName)
+	return nil
+}
+
+// DELETE /<bucket>?website handler
+func deleteBucketWebsite(bucketName string) error {
+	err := setBucketWebsite(bucketName, "", nil)
+	if err != nil {
+		return err
+	}
+	log.Printf("website configuration of <%s> bucket deleted", bucketName)
+	return nil
+}
+
+// Replaces the website configuration of the bucket and persists the buckets metadata
+func setBucketWebsite(bucketName, websiteXML string, website *websiteConfiguration) error {
+	return setBucketConfig(bucketName, func(bucket *bucketData) func() {
+		previousXML, previous := bucket.websiteXML, bucket.website
+		bucket.websiteXML, bucket.website = websiteXML, website
+		return func() {
+			bucket.websiteXML, bucket.website = previousXML, previous
+		}
+	})
+}
+
+// Parses the stored website configuration, an empty configuration is no configuration
+func loadBucketWebsite(bucket *bucketData) error {
+	if bucket.websiteXML == "" {
+		return nil
+	}
+	website, err := parseWebsite([]byte(bucket.websiteXML))
+	if err != nil {
+		return fmt.Errorf("error while parsing website configuration of <%s> bucket: %w", bucket.Name, err)
+	}
+	bucket.website = website
+	return nil
+}
@@ -10,6 +10,20 @@ import (
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && (os.Args[1] == "fsck" || os.Args[1] == "presign") {
+		run := web.Fsck
+		if os.Args[1] == "presign" {
+			run = web.Presign
+		}
+		err := run(os.Args[2:])
+		if err != nil && err != web.ErrHelpCalled {
+			log.Print(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// RESTful API router initialization
 	err := web.Init()
 	if err != nil {
@@ -27,6 +41,14 @@ func main() {
 
 	log.Print("starting server on: ", web.Port)
 
+	// Static website endpoint
+	if web.WebsitePort != 0 {
+		go func() {
+			log.Print("starting website server on: ", web.WebsitePort)
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", web.WebsitePort), web.WebsiteRoutes()))
+		}()
+	}
+
 	err = http.ListenAndServe(fmt.Sprintf(":%d", web.Port), mux)
 
 	log.Fatal(err)